@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/url"
-	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -15,58 +14,90 @@ import (
 	"github.com/chanzuckerberg/fogg/plan"
 	"github.com/chanzuckerberg/fogg/plugins"
 	"github.com/chanzuckerberg/fogg/templates"
-	"github.com/chanzuckerberg/fogg/util"
-	"github.com/gobuffalo/packr"
 	getter "github.com/hashicorp/go-getter"
+	"github.com/hashicorp/hcl/hcl/parser"
 	"github.com/hashicorp/hcl/hcl/printer"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 )
 
 const rootPath = "terraform"
 
-func Apply(fs afero.Fs, conf *config.Config, tmp *templates.T) error {
+// Options change what Apply does besides generating files.
+type Options struct {
+	// Prune removes the files an earlier apply generated that this one
+	// doesn't, otherwise they're only reported.
+	Prune bool
+	// PruneUserFiles lets Prune remove directories that have files from
+	// .touch and .create templates in them.
+	PruneUserFiles bool
+	// Force overwrites generated files even if they were edited since fogg
+	// wrote them.
+	Force bool
+	// Only limits generation to these accounts, envs, components, modules,
+	// global or plugins, see plan.Select. The repo files and the Makefiles of the
+	// accounts and envs they're in are always generated.
+	Only []string
+	// Parallelism is how many scopes are generated at once, it defaults to
+	// DefaultParallelism.
+	Parallelism int
+
+	// dryRun is set by DryRun. Plugins aren't installed, they aren't in the
+	// manifest and installing them can mean downloading them.
+	dryRun bool
+}
+
+func Apply(fs afero.Fs, conf *config.Config, tmp *templates.T, opts Options) error {
 	p, err := plan.Eval(conf, false)
 	if err != nil {
 		return errors.Wrap(err, "unable to evaluate plan")
 	}
 
-	e := applyRepo(fs, p, &tmp.Repo)
+	selection, e := plan.Select(p, opts.Only)
 	if e != nil {
-		return errors.Wrap(e, "unable to apply repo")
+		return e
 	}
-
-	e = applyAccounts(fs, p, &tmp.Account)
+	set, e := tmp.Compile()
 	if e != nil {
-		return errors.Wrap(e, "unable to apply accounts")
+		return errors.Wrap(e, "unable to compile templates")
 	}
 
-	e = applyEnvs(fs, p, &tmp.Env, &tmp.Component)
+	previous, e := ReadManifest(fs)
 	if e != nil {
-		return errors.Wrap(e, "unable to apply envs")
+		return e
+	}
+	jobs := generateJobs(p, set, selection, !opts.dryRun)
+	modules := map[string]*moduleDownload{}
+	if !opts.Force {
+		e = checkEdited(fs, jobs, selection, previous, opts, modules)
+		if e != nil {
+			return e
+		}
 	}
 
-	e = applyGlobal(fs, p.Global, &tmp.Global)
+	manifest, e := generate(fs, jobs, opts.Parallelism, modules)
 	if e != nil {
-		return errors.Wrap(e, "unable to apply global")
+		return e
 	}
-
-	e = applyModules(fs, p.Modules, &tmp.Module)
-	return errors.Wrap(e, "unable to apply modules")
-}
-
-func applyRepo(fs afero.Fs, p *plan.Plan, repoTemplates *packr.Box) error {
-	e := applyTree(fs, repoTemplates, "", p)
+	e = manifest.hashFiles(fs)
 	if e != nil {
 		return e
 	}
-	return applyPlugins(fs, p)
+	manifest.keepUnselected(previous, selection)
+	e = manifest.removeOrphans(fs, previous, opts)
+	if e != nil {
+		return errors.Wrap(e, "unable to prune")
+	}
+	return manifest.write(fs)
+}
+
+func applyRepo(fs afero.Fs, p *plan.Plan, repoTemplates *templates.Tree) error {
+	return applyTree(fs, repoTemplates, "", p)
 }
 
 func applyPlugins(fs afero.Fs, p *plan.Plan) (err error) {
 	apply := func(name string, plugin *plugins.CustomPlugin) error {
-		log.Infof("Applying plugin %s", name)
+		logger(fs).Infof("Applying plugin %s", name)
 		return errors.Wrapf(plugin.Install(fs, name), "Error applying plugin %s", name)
 	}
 
@@ -86,83 +117,121 @@ func applyPlugins(fs afero.Fs, p *plan.Plan) (err error) {
 	return
 }
 
-func applyGlobal(fs afero.Fs, p plan.Component, repoBox *packr.Box) error {
+func applyGlobal(fs afero.Fs, p plan.Component, set *templates.Set) error {
 	path := fmt.Sprintf("%s/global", rootPath)
 	e := fs.MkdirAll(path, 0755)
 	if e != nil {
 		return errors.Wrapf(e, "unable to make directory %s", path)
 	}
-	return applyTree(fs, repoBox, path, p)
-}
-
-func applyAccounts(fs afero.Fs, p *plan.Plan, accountBox *packr.Box) (e error) {
-	for account, accountPlan := range p.Accounts {
-		path := fmt.Sprintf("%s/accounts/%s", rootPath, account)
-		e = fs.MkdirAll(path, 0755)
-		if e != nil {
-			return errors.Wrap(e, "unable to make directories for accounts")
-		}
-		e = applyTree(fs, accountBox, path, accountPlan)
+	e = applyTree(fs, set.Global, path, p)
+	if e != nil {
+		return e
+	}
+	if p.ModuleSource != nil {
+		e = applyModuleInvocation(fs, path, *p.ModuleSource, set.ModuleInvocation)
 		if e != nil {
-			return errors.Wrap(e, "unable to apply templates to account")
+			return errors.Wrap(e, "unable to apply module invocation")
 		}
 	}
 	return nil
 }
 
-func applyModules(fs afero.Fs, p map[string]plan.Module, moduleBox *packr.Box) (e error) {
-	for module, modulePlan := range p {
-		path := fmt.Sprintf("%s/modules/%s", rootPath, module)
-		e = fs.MkdirAll(path, 0755)
-		if e != nil {
-			return errors.Wrapf(e, "unable to make path %s", path)
+func accountJobs(p *plan.Plan, selection *plan.Selection, set *templates.Set) []job {
+	var jobs []job
+	for _, account := range sortedKeys(p.Accounts) {
+		accountPlan := p.Accounts[account]
+		if !selection.Contains("accounts/" + account) {
+			continue
 		}
-		e = applyTree(fs, moduleBox, path, modulePlan)
-		if e != nil {
-			return errors.Wrap(e, "unable to apply tree")
+		path := fmt.Sprintf("%s/accounts/%s", rootPath, account)
+		jobs = append(jobs, job{"accounts/" + account, func(fs afero.Fs) error {
+			e := fs.MkdirAll(path, 0755)
+			if e != nil {
+				return errors.Wrap(e, "unable to make directories for accounts")
+			}
+			return errors.Wrap(applyTree(fs, set.Account, path, accountPlan), "unable to apply templates to account")
+		}})
+		for _, component := range sortedKeys(accountPlan.Components) {
+			jobs = append(jobs, componentJob(fmt.Sprintf("accounts/%s/%s", account, component), accountPlan.Components[component], selection, set)...)
 		}
 	}
-	return nil
+	return jobs
 }
 
-func applyEnvs(fs afero.Fs, p *plan.Plan, envBox *packr.Box, componentBox *packr.Box) (e error) {
-	for env, envPlan := range p.Envs {
-		path := fmt.Sprintf("%s/envs/%s", rootPath, env)
-		e = fs.MkdirAll(path, 0755)
-		if e != nil {
-			return errors.Wrapf(e, "unable to make directory %s", path)
-		}
-		e := applyTree(fs, envBox, path, envPlan)
-		if e != nil {
-			return errors.Wrap(e, "unable to apply templates to env")
+func moduleJobs(p map[string]plan.Module, selection *plan.Selection, set *templates.Set) []job {
+	var jobs []job
+	for _, module := range sortedKeys(p) {
+		modulePlan := p[module]
+		if !selection.Selected("modules/" + module) {
+			continue
 		}
-		for component, componentPlan := range envPlan.Components {
-			path = fmt.Sprintf("%s/envs/%s/%s", rootPath, env, component)
-			e = fs.MkdirAll(path, 0755)
+		path := fmt.Sprintf("%s/modules/%s", rootPath, module)
+		jobs = append(jobs, job{"modules/" + module, func(fs afero.Fs) error {
+			e := fs.MkdirAll(path, 0755)
 			if e != nil {
-				return errors.Wrap(e, "unable to make directories for component")
+				return errors.Wrapf(e, "unable to make path %s", path)
 			}
-			e := applyTree(fs, componentBox, path, componentPlan)
+			return errors.Wrap(applyTree(fs, set.Module, path, modulePlan), "unable to apply tree")
+		}})
+	}
+	return jobs
+}
+
+func envJobs(p *plan.Plan, selection *plan.Selection, set *templates.Set) []job {
+	var jobs []job
+	for _, env := range sortedKeys(p.Envs) {
+		envPlan := p.Envs[env]
+		if !selection.Contains("envs/" + env) {
+			continue
+		}
+		path := fmt.Sprintf("%s/envs/%s", rootPath, env)
+		jobs = append(jobs, job{"envs/" + env, func(fs afero.Fs) error {
+			e := fs.MkdirAll(path, 0755)
 			if e != nil {
-				return errors.Wrap(e, "unable to apply templates for component")
+				return errors.Wrapf(e, "unable to make directory %s", path)
 			}
+			return errors.Wrap(applyTree(fs, set.Env, path, envPlan), "unable to apply templates to env")
+		}})
+		for _, component := range sortedKeys(envPlan.Components) {
+			jobs = append(jobs, componentJob(fmt.Sprintf("envs/%s/%s", env, component), envPlan.Components[component], selection, set)...)
+		}
+	}
+	return jobs
+}
 
-			if componentPlan.ModuleSource != nil {
-				e := applyModuleInvocation(fs, path, *componentPlan.ModuleSource, templates.Templates.ModuleInvocation)
-				if e != nil {
-					return errors.Wrap(e, "unable to apply module invocation")
-				}
-			}
+// componentJob is the job for the component at address, if it's selected.
+func componentJob(address string, componentPlan plan.Component, selection *plan.Selection, set *templates.Set) []job {
+	if !selection.Selected(address) {
+		return nil
+	}
+	return []job{{address, func(fs afero.Fs) error {
+		return applyComponent(fs, rootPath+"/"+address, componentPlan, set)
+	}}}
+}
 
+func applyComponent(fs afero.Fs, path string, componentPlan plan.Component, set *templates.Set) error {
+	e := fs.MkdirAll(path, 0755)
+	if e != nil {
+		return errors.Wrap(e, "unable to make directories for component")
+	}
+	e = applyTree(fs, set.Component, path, componentPlan)
+	if e != nil {
+		return errors.Wrap(e, "unable to apply templates for component")
+	}
+
+	if componentPlan.ModuleSource != nil {
+		e = applyModuleInvocation(fs, path, *componentPlan.ModuleSource, set.ModuleInvocation)
+		if e != nil {
+			return errors.Wrap(e, "unable to apply module invocation")
 		}
 	}
 	return nil
 }
 
-func applyTree(dest afero.Fs, source *packr.Box, targetBasePath string, subst interface{}) (e error) {
-	return source.Walk(func(path string, sourceFile packr.File) error {
-		extension := filepath.Ext(path)
-		target := getTargetPath(targetBasePath, path)
+func applyTree(dest afero.Fs, source *templates.Tree, targetBasePath string, subst interface{}) (e error) {
+	for _, sourceFile := range source.Files {
+		extension := filepath.Ext(sourceFile.Path)
+		target := getTargetPath(targetBasePath, sourceFile.Path)
 
 		targetExtension := filepath.Ext(target)
 		if extension == ".tmpl" {
@@ -170,22 +239,26 @@ func applyTree(dest afero.Fs, source *packr.Box, targetBasePath string, subst in
 			if e != nil {
 				return errors.Wrap(e, "unable to apply template")
 			}
+			recordGenerated(dest, target, sourceFile.Source, false)
 		} else if extension == ".touch" {
 			e = touchFile(dest, target)
 			if e != nil {
 				return errors.Wrapf(e, "unable to touch file %s", target)
 			}
+			recordGenerated(dest, target, sourceFile.Source, true)
 		} else if extension == ".create" {
-			e = createFile(dest, target, sourceFile)
+			e = createFile(dest, target, bytes.NewReader(sourceFile.Contents))
 			if e != nil {
 				return errors.Wrapf(e, "unable to create file %s", target)
 			}
+			recordGenerated(dest, target, sourceFile.Source, true)
 		} else {
-			log.Infof("%s copied", target)
-			e = afero.WriteReader(dest, target, sourceFile)
+			logger(dest).Infof("%s copied", target)
+			e = afero.WriteReader(dest, target, bytes.NewReader(sourceFile.Contents))
 			if e != nil {
 				return errors.Wrap(e, "unable to copy file")
 			}
+			recordGenerated(dest, target, sourceFile.Source, false)
 		}
 
 		if targetExtension == ".tf" {
@@ -194,8 +267,8 @@ func applyTree(dest afero.Fs, source *packr.Box, targetBasePath string, subst in
 				return errors.Wrap(e, "unable to format HCL")
 			}
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
 func fmtHcl(fs afero.Fs, path string) error {
@@ -213,13 +286,13 @@ func fmtHcl(fs afero.Fs, path string) error {
 func touchFile(dest afero.Fs, path string) error {
 	_, err := dest.Stat(path)
 	if err != nil { // TODO we might not want to do this for all errors
-		log.Infof("%s touched", path)
+		logger(dest).Infof("%s touched", path)
 		_, err = dest.Create(path)
 		if err != nil {
 			return errors.Wrap(err, "unable to touch file")
 		}
 	} else {
-		log.Infof("%s skipped touch", path)
+		logger(dest).Infof("%s skipped touch", path)
 	}
 	return nil
 }
@@ -227,13 +300,13 @@ func touchFile(dest afero.Fs, path string) error {
 func createFile(dest afero.Fs, path string, sourceFile io.Reader) error {
 	_, err := dest.Stat(path)
 	if err != nil { // TODO we might not want to do this for all errors
-		log.Infof("%s created", path)
+		logger(dest).Infof("%s created", path)
 		err = afero.WriteReader(dest, path, sourceFile)
 		if err != nil {
 			return errors.Wrap(err, "unable to create file")
 		}
 	} else {
-		log.Infof("%s skipped", path)
+		logger(dest).Infof("%s skipped", path)
 	}
 	return nil
 }
@@ -242,14 +315,40 @@ func removeExtension(path string) string {
 	return strings.TrimSuffix(path, filepath.Ext(path))
 }
 
-func applyTemplate(sourceFile io.Reader, dest afero.Fs, path string, overrides interface{}) error {
-	log.Infof("%s templated", path)
-	writer, err := dest.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+func applyTemplate(sourceFile *templates.File, dest afero.Fs, path string, overrides interface{}) error {
+	logger(dest).Infof("%s templated", path)
+	buf := &bytes.Buffer{}
+	err := sourceFile.Execute(buf, overrides)
 	if err != nil {
-		return errors.Wrap(err, "unable to open file")
+		return errors.Wrapf(err, "unable to render %s", path)
+	}
+	if filepath.Ext(path) == ".tf" {
+		err = checkHcl(path, buf.Bytes())
+		if err != nil {
+			return err
+		}
 	}
-	t := util.OpenTemplate(sourceFile)
-	return t.Execute(writer, overrides)
+	return afero.WriteFile(dest, path, buf.Bytes(), 0755)
+}
+
+// checkHcl makes sure rendered terraform parses before we write it. When it
+// doesn't it's almost always a config value the template didn't expect, so
+// the error shows the line it's on.
+func checkHcl(path string, b []byte) error {
+	_, e := parser.Parse(b)
+	if e == nil {
+		return nil
+	}
+	pe, ok := e.(*parser.PosError)
+	if !ok {
+		return errors.Wrapf(e, "%s is not valid HCL", path)
+	}
+	lines := strings.Split(string(b), "\n")
+	line := ""
+	if pe.Pos.Line > 0 && pe.Pos.Line <= len(lines) {
+		line = strings.TrimSpace(lines[pe.Pos.Line-1])
+	}
+	return errors.Errorf("%s:%d:%d: %s, check the config values in %s", path, pe.Pos.Line, pe.Pos.Column, pe.Err, line)
 }
 
 // This should really be part of the plan stage, not apply. But going to
@@ -262,13 +361,13 @@ type moduleData struct {
 	Outputs      []string
 }
 
-func applyModuleInvocation(fs afero.Fs, path, moduleAddress string, box packr.Box) error {
+func applyModuleInvocation(fs afero.Fs, path, moduleAddress string, tree *templates.Tree) error {
 	e := fs.MkdirAll(path, 0755)
 	if e != nil {
 		return errors.Wrapf(e, "couldn't create %s directory", path)
 	}
 
-	moduleConfig, e := util.DownloadAndParseModule(moduleAddress)
+	moduleConfig, e := downloadModule(fs, moduleAddress)
 	if e != nil {
 		return errors.Wrap(e, "could not download or parse module")
 	}
@@ -292,9 +391,9 @@ func applyModuleInvocation(fs afero.Fs, path, moduleAddress string, box packr.Bo
 
 	moduleAddressForSource, _ := calculateModuleAddressForSource(path, moduleAddress)
 	// MAIN
-	f, e := box.Open("main.tf.tmpl")
+	f, e := tree.File("main.tf.tmpl")
 	if e != nil {
-		return errors.Wrap(e, "could not open template file")
+		return e
 	}
 	e = applyTemplate(f, fs, filepath.Join(path, "main.tf"), &moduleData{moduleName, moduleAddressForSource, variables, outputs})
 	if e != nil {
@@ -304,11 +403,12 @@ func applyModuleInvocation(fs afero.Fs, path, moduleAddress string, box packr.Bo
 	if e != nil {
 		return errors.Wrap(e, "unable to format main.tf")
 	}
+	recordGenerated(fs, filepath.Join(path, "main.tf"), f.Source, false)
 
 	// OUTPUTS
-	f, e = box.Open("outputs.tf.tmpl")
+	f, e = tree.File("outputs.tf.tmpl")
 	if e != nil {
-		return errors.Wrap(e, "could not open template file")
+		return e
 	}
 
 	e = applyTemplate(f, fs, filepath.Join(path, "outputs.tf"), &moduleData{moduleName, moduleAddressForSource, variables, outputs})
@@ -320,6 +420,7 @@ func applyModuleInvocation(fs afero.Fs, path, moduleAddress string, box packr.Bo
 	if e != nil {
 		return errors.Wrap(e, "unable to format outputs.tf")
 	}
+	recordGenerated(fs, filepath.Join(path, "outputs.tf"), f.Source, false)
 
 	return nil
 }
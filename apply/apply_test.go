@@ -1,13 +1,23 @@
 package apply
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/chanzuckerberg/fogg/config"
+	"github.com/chanzuckerberg/fogg/plugins"
 	"github.com/chanzuckerberg/fogg/templates"
+	multierror "github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -35,12 +45,13 @@ func TestRemoveExtension(t *testing.T) {
 }
 
 func TestApplyTemplateBasic(t *testing.T) {
-	sourceFile := strings.NewReader("foo")
+	sourceFile, e := templates.Parse("foo.tmpl", []byte("foo"))
+	assert.Nil(t, e)
 	dest := afero.NewMemMapFs()
 	path := "bar"
 	overrides := struct{ Foo string }{"foo"}
 
-	e := applyTemplate(sourceFile, dest, path, overrides)
+	e = applyTemplate(sourceFile, dest, path, overrides)
 	assert.Nil(t, e)
 	f, e := dest.Open("bar")
 	assert.Nil(t, e)
@@ -50,12 +61,13 @@ func TestApplyTemplateBasic(t *testing.T) {
 }
 
 func TestApplyTemplate(t *testing.T) {
-	sourceFile := strings.NewReader("Hello {{.Name}}")
+	sourceFile, e := templates.Parse("hello.tmpl", []byte("Hello {{.Name}}"))
+	assert.Nil(t, e)
 	dest := afero.NewMemMapFs()
 	path := "hello"
 	overrides := struct{ Name string }{"World"}
 
-	e := applyTemplate(sourceFile, dest, path, overrides)
+	e = applyTemplate(sourceFile, dest, path, overrides)
 	assert.Nil(t, e)
 	f, e := dest.Open("hello")
 	assert.Nil(t, e)
@@ -64,6 +76,29 @@ func TestApplyTemplate(t *testing.T) {
 	assert.Equal(t, "Hello World", string(r))
 }
 
+func TestApplyTemplateEscapesHCL(t *testing.T) {
+	sourceFile, e := templates.Parse("fogg.tf.tmpl", []byte(`owner = "{{ .Owner | hclEscape }}"`))
+	assert.Nil(t, e)
+	dest := afero.NewMemMapFs()
+	overrides := struct{ Owner string }{`a "quoted" \ ${var.owner}`}
+
+	e = applyTemplate(sourceFile, dest, "fogg.tf", overrides)
+	assert.Nil(t, e)
+	r, e := readFile(dest, "fogg.tf")
+	assert.Nil(t, e)
+	assert.Equal(t, `owner = "a \"quoted\" \\ $${var.owner}"`, r)
+
+	// without escaping the output isn't HCL, and isn't written
+	sourceFile, e = templates.Parse("bad.tf.tmpl", []byte("foo = \"bar\"\nowner = \"{{ .Owner }}\"\n"))
+	assert.Nil(t, e)
+	e = applyTemplate(sourceFile, dest, "bad.tf", overrides)
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "bad.tf:2:")
+	assert.Contains(t, e.Error(), `check the config values in owner = "a "quoted" \ ${var.owner}"`)
+	_, e = dest.Stat("bad.tf")
+	assert.True(t, os.IsNotExist(e))
+}
+
 func TestTouchFile(t *testing.T) {
 	fs := afero.NewMemMapFs()
 
@@ -126,8 +161,10 @@ func TestApplySmokeTest(t *testing.T) {
 	json := `
 {
   "defaults": {
-    "aws_region": "reg",
-    "aws_profile": "prof",
+    "aws_region_backend": "reg",
+    "aws_region_provider": "reg",
+    "aws_profile_backend": "prof",
+    "aws_profile_provider": "prof",
     "infra_s3_bucket": "buck",
     "project": "proj",
     "terraform_version": "0.100.0",
@@ -159,14 +196,139 @@ func TestApplySmokeTest(t *testing.T) {
 	c, e := config.ReadConfig(ioutil.NopCloser(strings.NewReader(json)))
 	assert.Nil(t, e)
 
-	e = Apply(fs, c, templates.Templates)
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+}
+
+func TestApplyGlobal(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := json.Unmarshal([]byte(`{"account_id": "000000000789", "infra_s3_bucket": "globalbuck"}`), &c.Global)
+	assert.Nil(t, e)
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	r, e := readFile(fs, "terraform/global/fogg.tf")
+	assert.Nil(t, e)
+	assert.Contains(t, r, `allowed_account_ids = ["000000000789"]`)
+
+	// global's state is read from global's own bucket
+	for _, path := range []string{"terraform/accounts/bar/fogg.tf", "terraform/envs/staging/comp1/fogg.tf"} {
+		r, e = readFile(fs, path)
+		assert.Nil(t, e)
+		assert.Contains(t, r, `data "terraform_remote_state" "global"`, path)
+		assert.Contains(t, r, `key     = "terraform/proj/global.tfstate"`, path)
+		assert.Contains(t, r, `bucket  = "globalbuck"`, path)
+	}
+}
+
+func TestApplyAccountComponents(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := json.Unmarshal([]byte(`{
+  "bar": {
+    "account_id": "012345678901",
+    "components": {
+      "iam": {},
+      "dns": {"owner": "dns@example.com", "depends_on": ["iam"]}
+    }
+  }
+}`), &c.Accounts)
+	assert.Nil(t, e)
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	r, e := readFile(fs, "terraform/accounts/bar/fogg.tf")
+	assert.Nil(t, e)
+	assert.Contains(t, r, `allowed_account_ids = ["012345678901"]`)
+	assert.Contains(t, r, `bar = "012345678901"`)
+	assert.Contains(t, r, `foo = "000000000123"`)
+
+	r, e = readFile(fs, "terraform/accounts/bar/dns/fogg.tf")
+	assert.Nil(t, e)
+	assert.Contains(t, r, `key = "terraform/proj/accounts/bar/components/dns.tfstate"`)
+	assert.Contains(t, r, `key     = "terraform/proj/accounts/bar/components/iam.tfstate"`)
+	assert.Contains(t, r, `allowed_account_ids = ["012345678901"]`)
+	assert.Contains(t, r, `default = "dns@example.com"`)
+}
+
+func TestApplyAccountMakefile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	foo := c.Accounts["foo"]
+	foo.Components = map[string]*config.Component{"iam": {}, "dns": {}}
+	c.Accounts["foo"] = foo
+	e := Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	r, e := readFile(fs, "terraform/accounts/foo/Makefile")
+	assert.Nil(t, e)
+	assert.Contains(t, r, "COMPONENTS=dns iam")
+	assert.Contains(t, r, "$(MAKE) -C $$c check-plan")
+}
+
+func TestApplyExtraVars(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := json.Unmarshal([]byte(`{
+  "cidrs": ["10.0.0.0/16"],
+  "labels": {"team": "infra", "app": "none"},
+  "settings": {"default": {"size": 3, "tier": "db"}, "description": "db settings"}
+}`), &c.Defaults.ExtraVars)
+	assert.Nil(t, e)
+	staging := c.Envs["staging"]
+	staging.ExtraVars["labels"] = config.ExtraVar{Default: map[string]interface{}{"app": "web"}}
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	r, e := readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
 	assert.Nil(t, e)
+	assert.Contains(t, r, `type    = "list"`)
+	assert.Contains(t, r, `default = ["10.0.0.0/16"]`)
+	assert.Contains(t, r, `description = "db settings"`)
+	assert.Contains(t, r, `size = 3`)
+	assert.Contains(t, r, `tier = "db"`)
+	// the env's labels are merged into the ones from defaults
+	assert.Contains(t, r, `team = "infra"`)
+	assert.Contains(t, r, `app  = "web"`)
+	assert.NotContains(t, r, `"none"`)
+}
+
+func TestApplyDependencies(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := json.Unmarshal([]byte(`{
+  "bar": {
+    "account_id": "000000000456",
+    "infra_s3_bucket": "barbuck",
+    "components": {"dns": {}}
+  }
+}`), &c.Accounts)
+	assert.Nil(t, e)
+	c.Envs["staging"].Components["comp1"].DependsOn = []string{"comp2", "accounts/bar/dns"}
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	r, e := readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	assert.Contains(t, r, `data "terraform_remote_state" "comp2"`)
+	assert.Contains(t, r, `key     = "terraform/proj/envs/staging/components/comp2.tfstate"`)
+	assert.Contains(t, r, `data "terraform_remote_state" "accounts_bar_dns"`)
+	assert.Contains(t, r, `key     = "terraform/proj/accounts/bar/components/dns.tfstate"`)
+	assert.Contains(t, r, `bucket  = "barbuck"`)
+	assert.NotContains(t, r, `data "terraform_remote_state" "vpc"`)
+
+	r, e = readFile(fs, "terraform/envs/staging/comp2/fogg.tf")
+	assert.Nil(t, e)
+	assert.NotContains(t, r, `data "terraform_remote_state" "comp1"`)
 }
 
 func TestApplyModuleInvocation(t *testing.T) {
 	fs := afero.NewMemMapFs()
 
-	e := applyModuleInvocation(fs, "mymodule", "../util/test-module", templates.Templates.ModuleInvocation)
+	set, e := templates.Templates.Compile()
+	assert.Nil(t, e)
+	e = applyModuleInvocation(fs, "mymodule", "../util/test-module", set.ModuleInvocation)
 	assert.Nil(t, e)
 
 	s, e := fs.Stat("mymodule")
@@ -295,3 +457,423 @@ func writeFile(fs afero.Fs, path string, contents string) error {
 	_, e = f.WriteString(contents)
 	return e
 }
+
+// readFixture reads the config in plan's fixtures. It's from before
+// version 3, so it's switched to depends_on, and its vpc module is on github,
+// so that's swapped for the test module.
+func readFixture(t *testing.T) *config.Config {
+	f, e := os.Open("../plan/fixtures/full.json")
+	assert.Nil(t, e)
+	defer f.Close()
+	c, e := config.ReadConfig(f)
+	assert.Nil(t, e)
+	c.Defaults.RemoteStateSiblings = false
+	source := "../util/test-module"
+	c.Envs["staging"].Components["vpc"].ModuleSource = &source
+	return c
+}
+
+func TestDryRun(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	fogg, e := readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	e = writeFile(fs, "terraform/envs/staging/comp1/fogg.tf", fogg+"# hotfix\n")
+	assert.Nil(t, e)
+	e = writeFile(fs, "terraform/envs/staging/comp1/README.md", "mine\n")
+	assert.Nil(t, e)
+
+	c.Envs["staging"].Components["comp3"] = &config.Component{}
+	changes, e := DryRun(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	actions := map[string]string{}
+	for _, change := range changes {
+		actions[change.Path] = string(change.Action)
+		if change.Path == "terraform/envs/staging/comp1/fogg.tf" {
+			assert.Contains(t, change.Diff, "--- a/terraform/envs/staging/comp1/fogg.tf")
+			assert.Contains(t, change.Diff, "-# hotfix")
+			assert.True(t, change.Edited)
+		} else {
+			assert.False(t, change.Edited, change.Path)
+		}
+	}
+	assert.Equal(t, string(Modified), actions["terraform/envs/staging/comp1/fogg.tf"])
+	assert.Equal(t, string(Skipped), actions["terraform/envs/staging/comp1/README.md"])
+	assert.Equal(t, string(Unchanged), actions["terraform/envs/staging/comp1/Makefile"])
+	assert.Equal(t, string(Modified), actions["terraform/envs/staging/Makefile"])
+	assert.Equal(t, string(Created), actions["terraform/envs/staging/comp3/fogg.tf"])
+	assert.Equal(t, string(Created), actions["terraform/envs/staging/comp3/main.tf"])
+	assert.NotContains(t, actions, ManifestPath)
+
+	stale := map[string]bool{}
+	for _, change := range Stale(changes) {
+		stale[change.Path] = true
+	}
+	assert.True(t, stale["terraform/envs/staging/comp1/fogg.tf"])
+	assert.True(t, stale["terraform/envs/staging/comp3/fogg.tf"])
+	assert.False(t, stale["terraform/envs/staging/comp1/Makefile"])
+	// user files are expected to differ from their templates
+	assert.False(t, stale["terraform/envs/staging/comp1/README.md"])
+	assert.False(t, stale["terraform/envs/staging/comp3/main.tf"])
+
+	// nothing was written
+	r, e := readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	assert.Equal(t, fogg+"# hotfix\n", r)
+	_, e = fs.Stat("terraform/envs/staging/comp3")
+	assert.True(t, os.IsNotExist(e))
+}
+
+func TestDryRunSkipsPlugins(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	// nothing listens here, installing the plugin would fail
+	c.Plugins.CustomPlugins = map[string]*plugins.CustomPlugin{
+		"terraform-provider-x": {URL: "http://127.0.0.1:1/x.tgz", Format: plugins.TypePluginFormatTar},
+	}
+	changes, e := DryRun(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+	for _, change := range changes {
+		assert.False(t, strings.HasPrefix(change.Path, ".bin"), change.Path)
+	}
+}
+
+func TestApplyPrune(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	m, e := ReadManifest(fs)
+	assert.Nil(t, e)
+	f := m.Files["terraform/envs/staging/comp2/fogg.tf"]
+	assert.Equal(t, "component/fogg.tf.tmpl", f.Template)
+	assert.Regexp(t, "^sha256:[0-9a-f]{64}$", f.Hash)
+	assert.False(t, f.UserFile)
+	assert.True(t, m.Files["terraform/envs/staging/comp2/main.tf"].UserFile)
+
+	delete(c.Envs["staging"].Components, "comp2")
+	delete(c.Accounts, "foo")
+
+	// orphans are only reported without --prune
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+	_, e = fs.Stat("terraform/accounts/foo/fogg.tf")
+	assert.Nil(t, e)
+	m, e = ReadManifest(fs)
+	assert.Nil(t, e)
+	assert.True(t, m.Files["terraform/accounts/foo/fogg.tf"].Orphan)
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	changes, e := DryRun(fs, c, templates.Templates, Options{Prune: true})
+	assert.Nil(t, e)
+	assert.NotContains(t, buf.String(), "no longer generated")
+	actions := map[string]string{}
+	for _, change := range changes {
+		actions[change.Path] = string(change.Action)
+	}
+	assert.Equal(t, string(Orphaned), actions["terraform/accounts/foo/fogg.tf"])
+	assert.Equal(t, string(Orphaned), actions["terraform/envs/staging/comp2/main.tf"])
+
+	// comp2 has user files in it, so it's kept
+	e = Apply(fs, c, templates.Templates, Options{Prune: true})
+	assert.Nil(t, e)
+	_, e = fs.Stat("terraform/accounts/foo")
+	assert.True(t, os.IsNotExist(e))
+	_, e = fs.Stat("terraform/envs/staging/comp2/fogg.tf")
+	assert.Nil(t, e)
+	m, e = ReadManifest(fs)
+	assert.Nil(t, e)
+	assert.NotContains(t, m.Files, "terraform/accounts/foo/fogg.tf")
+	assert.True(t, m.Files["terraform/envs/staging/comp2/fogg.tf"].Orphan)
+
+	e = Apply(fs, c, templates.Templates, Options{Prune: true, PruneUserFiles: true})
+	assert.Nil(t, e)
+	_, e = fs.Stat("terraform/envs/staging/comp2")
+	assert.True(t, os.IsNotExist(e))
+	_, e = fs.Stat("terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	m, e = ReadManifest(fs)
+	assert.Nil(t, e)
+	for path, f := range m.Files {
+		assert.False(t, f.Orphan, path)
+	}
+}
+
+func TestApplyEdited(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	e := Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	// edits to user files are fine
+	e = writeFile(fs, "terraform/envs/staging/comp1/main.tf", "# mine\n")
+	assert.Nil(t, e)
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	fogg, e := readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	e = writeFile(fs, "terraform/envs/staging/comp1/fogg.tf", fogg+"# hotfix\n")
+	assert.Nil(t, e)
+
+	c.Envs["staging"].Components["comp3"] = &config.Component{}
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Equal(t, EditedError{Paths: []string{"terraform/envs/staging/comp1/fogg.tf"}}, e)
+	// nothing was written
+	r, e := readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	assert.Contains(t, r, "# hotfix")
+	_, e = fs.Stat("terraform/envs/staging/comp3")
+	assert.True(t, os.IsNotExist(e))
+
+	// removing it with --prune would lose the edit too
+	delete(c.Envs["staging"].Components, "comp1")
+	e = Apply(fs, c, templates.Templates, Options{Prune: true, PruneUserFiles: true})
+	assert.Equal(t, EditedError{Paths: []string{"terraform/envs/staging/comp1/fogg.tf"}}, e)
+	c.Envs["staging"].Components["comp1"] = &config.Component{}
+
+	e = Apply(fs, c, templates.Templates, Options{Force: true})
+	assert.Nil(t, e)
+	r, e = readFile(fs, "terraform/envs/staging/comp1/fogg.tf")
+	assert.Nil(t, e)
+	assert.Equal(t, fogg, r)
+	_, e = fs.Stat("terraform/envs/staging/comp3/fogg.tf")
+	assert.Nil(t, e)
+}
+
+func TestCheckEdited(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	previous := newManifest()
+	previous.Files["terraform/envs/staging/db/fogg.tf"] = ManifestFile{Template: "component/fogg.tf.tmpl", Hash: hash([]byte("old\n"))}
+	e := writeFile(fs, "terraform/envs/staging/db/fogg.tf", "edited\n")
+	assert.Nil(t, e)
+
+	var mu sync.Mutex
+	var ran []string
+	var jobs []job
+	for _, scope := range []string{"repo", "plugins", "envs/staging", "envs/staging/db", "envs/staging/web"} {
+		scope := scope
+		jobs = append(jobs, job{scope, func(fs afero.Fs) error {
+			mu.Lock()
+			ran = append(ran, scope)
+			mu.Unlock()
+			if scope != "envs/staging/db" {
+				return nil
+			}
+			_, e := downloadModule(fs, "../util/test-module")
+			if e != nil {
+				return e
+			}
+			recordGenerated(fs, "terraform/envs/staging/db/fogg.tf", "component/fogg.tf.tmpl", false)
+			return writeFile(fs, "terraform/envs/staging/db/fogg.tf", "new\n")
+		}})
+	}
+
+	modules := map[string]*moduleDownload{}
+	e = checkEdited(fs, jobs, nil, previous, Options{}, modules)
+	assert.Equal(t, EditedError{Paths: []string{"terraform/envs/staging/db/fogg.tf"}}, e)
+	// only the edited file's scope is generated to check it
+	assert.Equal(t, []string{"envs/staging/db"}, ran)
+	// and apply doesn't download its module again
+	assert.Contains(t, modules, "../util/test-module")
+}
+
+func TestScopeOf(t *testing.T) {
+	assert.Equal(t, "repo", scopeOf("Makefile"))
+	assert.Equal(t, "repo", scopeOf("scripts/docker-ssh-mount.sh"))
+	assert.Equal(t, "global", scopeOf("terraform/global/fogg.tf"))
+	assert.Equal(t, "modules/vpc", scopeOf("terraform/modules/vpc/main.tf"))
+	assert.Equal(t, "accounts/prod", scopeOf("terraform/accounts/prod/Makefile"))
+	assert.Equal(t, "accounts/prod/dns", scopeOf("terraform/accounts/prod/dns/fogg.tf"))
+	assert.Equal(t, "envs/staging", scopeOf("terraform/envs/staging/Makefile"))
+	assert.Equal(t, "envs/staging/db", scopeOf("terraform/envs/staging/db/fogg.tf"))
+}
+
+func TestApplyOnly(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	foo := c.Accounts["foo"]
+	foo.Components = map[string]*config.Component{"dns": {}}
+	c.Accounts["foo"] = foo
+	prod := c.Envs["prod"]
+	prod.Components = map[string]*config.Component{"comp1": {}}
+	c.Envs["prod"] = prod
+
+	e := Apply(fs, c, templates.Templates, Options{Only: []string{"envs/*/comp1", "accounts/foo"}})
+	assert.Nil(t, e)
+	for _, path := range []string{
+		"Makefile",
+		"terraform/envs/staging/Makefile",
+		"terraform/envs/staging/comp1/fogg.tf",
+		"terraform/envs/prod/comp1/fogg.tf",
+		"terraform/accounts/foo/fogg.tf",
+		"terraform/accounts/foo/dns/fogg.tf",
+	} {
+		_, e = fs.Stat(path)
+		assert.Nil(t, e, path)
+	}
+	for _, path := range []string{
+		"terraform/envs/staging/comp2",
+		"terraform/accounts/bar",
+		"terraform/global",
+	} {
+		_, e = fs.Stat(path)
+		assert.True(t, os.IsNotExist(e), path)
+	}
+	// the parent Makefile still lists every component
+	r, e := readFile(fs, "terraform/envs/staging/Makefile")
+	assert.Nil(t, e)
+	assert.Contains(t, r, "COMPONENTS=comp1 comp2 vpc")
+
+	e = Apply(fs, c, templates.Templates, Options{})
+	assert.Nil(t, e)
+
+	// files outside of the selection aren't orphans
+	e = Apply(fs, c, templates.Templates, Options{Only: []string{"envs/prod"}, Prune: true, PruneUserFiles: true})
+	assert.Nil(t, e)
+	_, e = fs.Stat("terraform/envs/staging/comp2/fogg.tf")
+	assert.Nil(t, e)
+	m, e := ReadManifest(fs)
+	assert.Nil(t, e)
+	assert.Contains(t, m.Files, "terraform/envs/staging/comp2/fogg.tf")
+
+	// but they are inside of it
+	delete(c.Envs["staging"].Components, "comp2")
+	e = Apply(fs, c, templates.Templates, Options{Only: []string{"envs/staging"}, Prune: true, PruneUserFiles: true})
+	assert.Nil(t, e)
+	_, e = fs.Stat("terraform/envs/staging/comp2")
+	assert.True(t, os.IsNotExist(e))
+
+	e = Apply(fs, c, templates.Templates, Options{Only: []string{"envs/nope"}})
+	assert.NotNil(t, e)
+}
+
+func TestApplyOnlySkipsPlugins(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := readFixture(t)
+	// nothing listens here, installing the plugin would fail
+	plugin := &plugins.CustomPlugin{URL: "http://127.0.0.1:1/x.tgz", Format: plugins.TypePluginFormatTar}
+	c.Plugins.CustomPlugins = map[string]*plugins.CustomPlugin{"terraform-provider-x": plugin}
+	e := Apply(fs, c, templates.Templates, Options{Only: []string{"envs/staging"}})
+	assert.Nil(t, e)
+
+	e = Apply(fs, c, templates.Templates, Options{Only: []string{"plugins"}})
+	assert.NotNil(t, e)
+}
+
+func TestRunJobs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	var running, most int32
+	var jobs []job
+	for i := 0; i < 20; i++ {
+		scope := fmt.Sprintf("envs/env%02d", i)
+		fail := i%7 == 3
+		jobs = append(jobs, job{scope, func(fs afero.Fs) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			logger(fs).Infof("%s first", scope)
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			logger(fs).Infof("%s second", scope)
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		}})
+	}
+
+	r := &run{manifest: newManifest(), modules: map[string]*moduleDownload{}}
+	e := r.runJobs(afero.NewMemMapFs(), jobs, 3)
+	assert.NotNil(t, e)
+	assert.True(t, most <= 3)
+
+	// every job's log is together, in order
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		lines = append(lines, line[strings.Index(line, "envs/"):strings.LastIndex(line, `"`)])
+	}
+	var expected []string
+	for i := 0; i < 20; i++ {
+		expected = append(expected, fmt.Sprintf("envs/env%02d first", i), fmt.Sprintf("envs/env%02d second", i))
+	}
+	assert.Equal(t, expected, lines)
+
+	// and so are the errors
+	merr, ok := e.(*multierror.Error)
+	assert.True(t, ok)
+	var failed []string
+	for _, err := range merr.Errors {
+		failed = append(failed, err.Error())
+	}
+	assert.Equal(t, []string{
+		"unable to apply envs/env03: failed",
+		"unable to apply envs/env10: failed",
+		"unable to apply envs/env17: failed",
+	}, failed)
+}
+
+func TestApplyParallelism(t *testing.T) {
+	c := readFixture(t)
+	source := "../util/test-module"
+	foo := c.Accounts["foo"]
+	foo.Components = map[string]*config.Component{"dns": {ModuleSource: &source}}
+	c.Accounts["foo"] = foo
+	c.Envs["staging"].Components["comp1"].ModuleSource = &source
+	prod := c.Envs["prod"]
+	prod.Components = map[string]*config.Component{"db": {ModuleSource: &source}}
+	c.Envs["prod"] = prod
+
+	serial := afero.NewMemMapFs()
+	e := Apply(serial, c, templates.Templates, Options{Parallelism: 1})
+	assert.Nil(t, e)
+	parallel := afero.NewMemMapFs()
+	e = Apply(parallel, c, templates.Templates, Options{Parallelism: 8})
+	assert.Nil(t, e)
+
+	a, e := readFile(serial, ManifestPath)
+	assert.Nil(t, e)
+	b, e := readFile(parallel, ManifestPath)
+	assert.Nil(t, e)
+	assert.Equal(t, a, b)
+	assert.Contains(t, a, "terraform/envs/prod/db/main.tf")
+}
+
+func TestDownloadModuleOnce(t *testing.T) {
+	r := &run{manifest: newManifest(), modules: map[string]*moduleDownload{}}
+	fs := &generateFs{Fs: afero.NewMemMapFs(), run: r, log: log.StandardLogger()}
+
+	var wg sync.WaitGroup
+	configs := make([]interface{}, 5)
+	for i := range configs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, e := downloadModule(fs, "../util/test-module")
+			assert.Nil(t, e)
+			configs[i] = c
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, r.modules, 1)
+	for _, c := range configs {
+		assert.True(t, c == configs[0])
+	}
+}
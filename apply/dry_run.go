@@ -0,0 +1,222 @@
+package apply
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/chanzuckerberg/fogg/config"
+	"github.com/chanzuckerberg/fogg/templates"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+)
+
+// Action is what applying would do to a file.
+type Action string
+
+const (
+	Created   Action = "created"
+	Modified  Action = "modified"
+	Unchanged Action = "unchanged"
+	// Skipped files are .touch and .create files that already exist, apply
+	// leaves them alone.
+	Skipped Action = "skipped"
+	// Orphaned files were generated by an earlier apply but aren't anymore.
+	Orphaned Action = "orphaned"
+)
+
+// FileChange is what applying would do to one file.
+type FileChange struct {
+	Path   string
+	Action Action
+	// Diff is a unified diff of the file for Modified files
+	Diff string
+	// UserFile is set for files from .touch and .create templates. They're
+	// only generated when missing, after that they belong to the user.
+	UserFile bool
+	// Edited is set for generated files that were changed by hand since fogg
+	// wrote them, applying needs --force.
+	Edited bool
+}
+
+// DryRun applies conf to an in-memory copy of fs and reports what would
+// change. fs is never written, so orphans are reported rather than pruned
+// and edited files are marked rather than refused. Plugins are left out.
+func DryRun(fs afero.Fs, conf *config.Config, tmp *templates.T, opts Options) ([]FileChange, error) {
+	previous, e := ReadManifest(fs)
+	if e != nil {
+		return nil, e
+	}
+	edited, e := previous.edited(fs)
+	if e != nil {
+		return nil, e
+	}
+
+	overlay := newOverlayFs(fs)
+	opts.Prune = false
+	opts.Force = true
+	opts.dryRun = true
+	e = Apply(overlay, conf, tmp, opts)
+	if e != nil {
+		return nil, e
+	}
+	manifest, e := ReadManifest(overlay)
+	if e != nil {
+		return nil, e
+	}
+	changes, e := overlay.changes(manifest)
+	if e != nil {
+		return nil, e
+	}
+	for i := range changes {
+		changes[i].Edited = edited[changes[i].Path]
+	}
+	return changes, nil
+}
+
+// overlayFs sends writes to an in-memory layer over a read only base.
+type overlayFs struct {
+	afero.Fs
+	base  afero.Fs
+	layer afero.Fs
+}
+
+func newOverlayFs(base afero.Fs) *overlayFs {
+	base = afero.NewReadOnlyFs(base)
+	layer := afero.NewMemMapFs()
+	return &overlayFs{
+		Fs:    afero.NewCopyOnWriteFs(base, layer),
+		base:  base,
+		layer: layer,
+	}
+}
+
+// MkdirAll doesn't fail for directories that already exist, like it doesn't
+// on the real fs. CopyOnWriteFs returns EEXIST when they're in the base.
+func (o *overlayFs) MkdirAll(path string, perm os.FileMode) error {
+	e := o.Fs.MkdirAll(path, perm)
+	if e == syscall.EEXIST {
+		if isDir, _ := afero.IsDir(o.base, path); isDir {
+			return nil
+		}
+	}
+	return e
+}
+
+// changes compares every file written to the layer with the base, sorted by
+// path. manifest is the one apply wrote, for user files and orphans.
+func (o *overlayFs) changes(manifest *Manifest) ([]FileChange, error) {
+	// user files that aren't created or changed were skipped
+	skipped := map[string]bool{}
+	for path, f := range manifest.Files {
+		if f.UserFile && !f.Orphan {
+			skipped[path] = true
+		}
+	}
+	var changes []FileChange
+	e := afero.Walk(o.layer, ".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		path = filepath.Clean(path)
+		// the manifest is fogg's own bookkeeping, not a generated file
+		if path == ManifestPath {
+			return nil
+		}
+		userFile := manifest.Files[path].UserFile
+		after, e := afero.ReadFile(o.layer, path)
+		if e != nil {
+			return errors.Wrapf(e, "unable to read %s", path)
+		}
+		before, e := afero.ReadFile(o.base, path)
+		if e != nil {
+			if os.IsNotExist(e) {
+				delete(skipped, path)
+				changes = append(changes, FileChange{Path: path, Action: Created, UserFile: userFile})
+				return nil
+			}
+			return errors.Wrapf(e, "unable to read %s", path)
+		}
+		if bytes.Equal(before, after) {
+			if skipped[path] {
+				return nil
+			}
+			changes = append(changes, FileChange{Path: path, Action: Unchanged, UserFile: userFile})
+			return nil
+		}
+		diff, e := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(before)),
+			B:        difflib.SplitLines(string(after)),
+			FromFile: "a/" + path,
+			ToFile:   "b/" + path,
+			Context:  3,
+		})
+		if e != nil {
+			return errors.Wrapf(e, "unable to diff %s", path)
+		}
+		// fmtHcl still formats .tf files apply skipped, that's a change
+		delete(skipped, path)
+		changes = append(changes, FileChange{Path: path, Action: Modified, Diff: diff, UserFile: userFile})
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	for path := range skipped {
+		changes = append(changes, FileChange{Path: path, Action: Skipped, UserFile: true})
+	}
+	for path, f := range manifest.Files {
+		if f.Orphan {
+			changes = append(changes, FileChange{Path: path, Action: Orphaned, UserFile: f.UserFile})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// PrintChanges writes one line per file, with the diff of modified files
+// after it, followed by a count of each action.
+func PrintChanges(w io.Writer, changes []FileChange) {
+	counts := map[Action]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+		if change.Edited {
+			fmt.Fprintf(w, "%-9s %s (edited by hand, apply needs --force)\n", change.Action, change.Path)
+		} else {
+			fmt.Fprintf(w, "%-9s %s\n", change.Action, change.Path)
+		}
+		if change.Diff != "" {
+			fmt.Fprint(w, change.Diff)
+		}
+	}
+	fmt.Fprintf(w, "%d %s, %d %s, %d %s, %d %s, %d %s\n",
+		counts[Created], Created, counts[Modified], Modified,
+		counts[Unchanged], Unchanged, counts[Skipped], Skipped,
+		counts[Orphaned], Orphaned)
+}
+
+// Stale is the changes that mean the generated files on disk are out of
+// date: ones that would be created, modified or are orphaned, other than
+// user files.
+func Stale(changes []FileChange) []FileChange {
+	var stale []FileChange
+	for _, change := range changes {
+		if change.UserFile {
+			continue
+		}
+		if change.Action == Created || change.Action == Modified || change.Action == Orphaned {
+			stale = append(stale, change)
+		}
+	}
+	return stale
+}
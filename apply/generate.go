@@ -0,0 +1,189 @@
+package apply
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/chanzuckerberg/fogg/plan"
+	"github.com/chanzuckerberg/fogg/templates"
+	"github.com/chanzuckerberg/fogg/util"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// DefaultParallelism is how many scopes apply generates at once unless told
+// otherwise.
+const DefaultParallelism = 10
+
+// job generates one scope, like the files of an env or one of its
+// components, named like the files' directory under terraform/, see
+// scopeOf. Jobs don't write any of the same files, so they can run at the
+// same time.
+type job struct {
+	scope string
+	apply func(fs afero.Fs) error
+}
+
+// generateJobs is the jobs that generate the parts of p in selection. The
+// repo files are always generated, plugins only when withPlugins is set and
+// they're selected.
+func generateJobs(p *plan.Plan, set *templates.Set, selection *plan.Selection, withPlugins bool) []job {
+	jobs := []job{
+		{"repo", func(fs afero.Fs) error {
+			return applyRepo(fs, p, set.Repo)
+		}},
+	}
+	if withPlugins && selection.Selected("plugins") {
+		jobs = append(jobs, job{"plugins", func(fs afero.Fs) error {
+			return applyPlugins(fs, p)
+		}})
+	}
+	jobs = append(jobs, accountJobs(p, selection, set)...)
+	jobs = append(jobs, envJobs(p, selection, set)...)
+	if selection.Selected("global") {
+		jobs = append(jobs, job{"global", func(fs afero.Fs) error {
+			return applyGlobal(fs, p.Global, set)
+		}})
+	}
+	jobs = append(jobs, moduleJobs(p.Modules, selection, set)...)
+	return jobs
+}
+
+// generate runs jobs against fs, up to parallelism at once, and returns the
+// manifest of what they wrote, without hashes. modules are the modules
+// downloaded so far, runs one after the other share them. Errors from every
+// job are returned together.
+func generate(fs afero.Fs, jobs []job, parallelism int, modules map[string]*moduleDownload) (*Manifest, error) {
+	r := &run{manifest: newManifest(), modules: modules}
+	return r.manifest, r.runJobs(fs, jobs, parallelism)
+}
+
+// run is what the jobs of one generate share.
+type run struct {
+	mu       sync.Mutex
+	manifest *Manifest
+	modules  map[string]*moduleDownload
+}
+
+// moduleDownload is a module that's downloaded once per run, however many
+// components use it.
+type moduleDownload struct {
+	once   sync.Once
+	config *config.Config
+	err    error
+}
+
+// runJobs runs jobs with a pool of parallelism workers. Each job logs to its
+// own buffer, which is written out in the order of jobs once it's done, so
+// the log of every scope stays together and comes out the same every time.
+func (r *run) runJobs(fs afero.Fs, jobs []job, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = DefaultParallelism
+	}
+	logs := make([]bytes.Buffer, len(jobs))
+	errs := make([]error, len(jobs))
+	done := make([]chan struct{}, len(jobs))
+	for i := range jobs {
+		done[i] = make(chan struct{})
+	}
+
+	next := make(chan int)
+	for w := 0; w < parallelism; w++ {
+		go func() {
+			for i := range next {
+				logger := log.New()
+				logger.Out = &logs[i]
+				logger.Formatter = log.StandardLogger().Formatter
+				logger.Level = log.GetLevel()
+				errs[i] = jobs[i].apply(&generateFs{Fs: fs, run: r, log: logger})
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range jobs {
+			next <- i
+		}
+		close(next)
+	}()
+
+	var result *multierror.Error
+	for i, j := range jobs {
+		<-done[i]
+		_, e := log.StandardLogger().Out.Write(logs[i].Bytes())
+		if e != nil {
+			log.Debugf("unable to write the log of %s: %s", j.scope, e)
+		}
+		if errs[i] != nil {
+			result = multierror.Append(result, errors.Wrapf(errs[i], "unable to apply %s", j.scope))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// generateFs is the fs a job writes to. It carries what the job shares with
+// the rest of the run, and the log of its scope.
+type generateFs struct {
+	afero.Fs
+	run *run
+	log *log.Logger
+}
+
+// logger is the log for the scope that's writing to fs.
+func logger(fs afero.Fs) log.FieldLogger {
+	if g, ok := fs.(*generateFs); ok {
+		return g.log
+	}
+	return log.StandardLogger()
+}
+
+// recordGenerated adds a file apply wrote to the manifest of the run.
+func recordGenerated(fs afero.Fs, path, template string, userFile bool) {
+	g, ok := fs.(*generateFs)
+	if !ok {
+		return
+	}
+	g.run.mu.Lock()
+	defer g.run.mu.Unlock()
+	g.run.manifest.Files[filepath.Clean(path)] = ManifestFile{Template: template, UserFile: userFile}
+}
+
+// downloadModule downloads and parses the module at address, only once per
+// run.
+func downloadModule(fs afero.Fs, address string) (*config.Config, error) {
+	g, ok := fs.(*generateFs)
+	if !ok {
+		return util.DownloadAndParseModule(address)
+	}
+	g.run.mu.Lock()
+	d, ok := g.run.modules[address]
+	if !ok {
+		d = &moduleDownload{}
+		g.run.modules[address] = d
+	}
+	g.run.mu.Unlock()
+
+	d.once.Do(func() {
+		g.log.Infof("downloading module %s", address)
+		d.config, d.err = util.DownloadAndParseModule(address)
+	})
+	return d.config, d.err
+}
+
+// sortedKeys is the keys of a map with string keys, sorted, so that jobs
+// always come in the same order.
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,312 @@
+package apply
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/chanzuckerberg/fogg/plan"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// ManifestPath is where apply keeps track of the files it generates.
+const ManifestPath = ".fogg/manifest.json"
+
+// Manifest is every file fogg generated, so that the next apply can find the
+// ones it doesn't generate anymore.
+type Manifest struct {
+	Files map[string]ManifestFile `json:"files"`
+}
+
+// ManifestFile is one generated file.
+type ManifestFile struct {
+	// Template is where the file came from, like component/fogg.tf.tmpl
+	Template string `json:"template"`
+	// Hash is the sha256 of what apply wrote, it's empty for user files
+	Hash string `json:"hash,omitempty"`
+	// UserFile is set for files from .touch and .create templates, which
+	// belong to the user once they exist.
+	UserFile bool `json:"user_file,omitempty"`
+	// Orphan is set for files an earlier apply generated that the last one
+	// didn't, and that haven't been pruned.
+	Orphan bool `json:"orphan,omitempty"`
+}
+
+func newManifest() *Manifest {
+	return &Manifest{Files: map[string]ManifestFile{}}
+}
+
+// ReadManifest reads the manifest apply last wrote to fs. It's empty if
+// there isn't one.
+func ReadManifest(fs afero.Fs) (*Manifest, error) {
+	b, e := afero.ReadFile(fs, ManifestPath)
+	if os.IsNotExist(e) {
+		return newManifest(), nil
+	}
+	if e != nil {
+		return nil, errors.Wrapf(e, "unable to read %s", ManifestPath)
+	}
+	m := newManifest()
+	e = json.Unmarshal(b, m)
+	if e != nil {
+		return nil, errors.Wrapf(e, "unable to parse %s", ManifestPath)
+	}
+	if m.Files == nil {
+		m.Files = map[string]ManifestFile{}
+	}
+	return m, nil
+}
+
+func (m *Manifest) write(fs afero.Fs) error {
+	e := fs.MkdirAll(filepath.Dir(ManifestPath), 0755)
+	if e != nil {
+		return errors.Wrapf(e, "unable to make directory %s", filepath.Dir(ManifestPath))
+	}
+	b, e := json.MarshalIndent(m, "", "  ")
+	if e != nil {
+		return errors.Wrap(e, "unable to encode manifest")
+	}
+	return errors.Wrapf(afero.WriteFile(fs, ManifestPath, append(b, '\n'), 0644), "unable to write %s", ManifestPath)
+}
+
+func hash(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
+
+// hashFiles hashes the generated files as they ended up on fs, which is
+// after fmtHcl.
+func (m *Manifest) hashFiles(fs afero.Fs) error {
+	for path, f := range m.Files {
+		if f.UserFile {
+			continue
+		}
+		b, e := afero.ReadFile(fs, path)
+		if e != nil {
+			return errors.Wrapf(e, "unable to read %s", path)
+		}
+		f.Hash = hash(b)
+		m.Files[path] = f
+	}
+	return nil
+}
+
+// orphans are the files in previous that m doesn't have and that are still
+// on fs, sorted.
+func (m *Manifest) orphans(fs afero.Fs, previous *Manifest) []string {
+	var orphans []string
+	for path := range previous.Files {
+		if _, ok := m.Files[path]; ok {
+			continue
+		}
+		if _, e := fs.Stat(path); e != nil {
+			continue
+		}
+		orphans = append(orphans, path)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// removeOrphans warns about the files in previous that apply doesn't
+// generate anymore, or removes them when opts.Prune is set, along with
+// directories that are left empty. A directory with orphaned user files in
+// it is left alone unless opts.PruneUserFiles is set. Orphans that are kept
+// stay in m, so that the next apply still knows about them. Dry runs don't
+// warn, they report orphans as changes.
+func (m *Manifest) removeOrphans(fs afero.Fs, previous *Manifest, opts Options) error {
+	orphans := m.orphans(fs, previous)
+	userDirs := map[string]bool{}
+	for _, path := range orphans {
+		if previous.Files[path].UserFile {
+			userDirs[filepath.Dir(path)] = true
+		}
+	}
+
+	for _, path := range orphans {
+		dir := filepath.Dir(path)
+		keep := func() {
+			f := previous.Files[path]
+			f.Orphan = true
+			m.Files[path] = f
+		}
+		if !opts.Prune {
+			if !opts.dryRun {
+				log.Warnf("%s is no longer generated, run fogg apply --prune to remove it", path)
+			}
+			keep()
+			continue
+		}
+		if userDirs[dir] && !opts.PruneUserFiles {
+			log.Warnf("%s not removed, %s has user files in it. Use --prune-user-files to remove them too", path, dir)
+			keep()
+			continue
+		}
+		e := fs.Remove(path)
+		if e != nil {
+			return errors.Wrapf(e, "unable to remove %s", path)
+		}
+		log.Infof("%s removed", path)
+		e = removeEmptyDirs(fs, dir)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and then its parents for as long as they're
+// empty.
+func removeEmptyDirs(fs afero.Fs, dir string) error {
+	for dir != "." && dir != string(filepath.Separator) {
+		empty, e := afero.IsEmpty(fs, dir)
+		if e != nil {
+			return errors.Wrapf(e, "unable to read directory %s", dir)
+		}
+		if !empty {
+			return nil
+		}
+		e = fs.Remove(dir)
+		if e != nil {
+			return errors.Wrapf(e, "unable to remove directory %s", dir)
+		}
+		log.Infof("%s removed", dir)
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
+// edited is the generated files in m that have been changed on fs since
+// apply wrote them.
+func (m *Manifest) edited(fs afero.Fs) (map[string]bool, error) {
+	edited := map[string]bool{}
+	for path, f := range m.Files {
+		if f.UserFile || f.Hash == "" {
+			continue
+		}
+		b, e := afero.ReadFile(fs, path)
+		if os.IsNotExist(e) {
+			continue
+		}
+		if e != nil {
+			return nil, errors.Wrapf(e, "unable to read %s", path)
+		}
+		if hash(b) != f.Hash {
+			edited[path] = true
+		}
+	}
+	return edited, nil
+}
+
+// EditedError is returned by Apply when it would overwrite or remove
+// generated files that were edited by hand.
+type EditedError struct {
+	Paths []string
+}
+
+func (e EditedError) Error() string {
+	return fmt.Sprintf("generated files were edited since fogg wrote them, use --force to overwrite them: %s", strings.Join(e.Paths, ", "))
+}
+
+// checkEdited returns an EditedError if running jobs would lose changes made
+// by hand to generated files. To find out without writing anything it runs
+// the jobs of the edited files' scopes into memory first, but only when
+// something was edited. The modules those jobs download are added to
+// modules, so that apply doesn't download them again.
+func checkEdited(fs afero.Fs, jobs []job, selection *plan.Selection, previous *Manifest, opts Options, modules map[string]*moduleDownload) error {
+	edited, e := previous.edited(fs)
+	if e != nil || len(edited) == 0 {
+		return e
+	}
+	scopes := map[string]bool{}
+	for path := range edited {
+		scopes[scopeOf(path)] = true
+	}
+	var check []job
+	for _, j := range jobs {
+		if scopes[j.scope] {
+			check = append(check, j)
+		}
+	}
+	overlay := newOverlayFs(fs)
+	manifest, e := generate(overlay, check, opts.Parallelism, modules)
+	if e != nil {
+		return e
+	}
+	manifest.keepUnselected(previous, selection)
+
+	var lost []string
+	for path := range edited {
+		if _, ok := manifest.Files[path]; !ok {
+			// orphans are only removed by --prune
+			if opts.Prune {
+				lost = append(lost, path)
+			}
+			continue
+		}
+		before, e := afero.ReadFile(fs, path)
+		if e != nil {
+			return errors.Wrapf(e, "unable to read %s", path)
+		}
+		after, e := afero.ReadFile(overlay, path)
+		if e != nil {
+			return errors.Wrapf(e, "unable to read %s", path)
+		}
+		if !bytes.Equal(before, after) {
+			lost = append(lost, path)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	sort.Strings(lost)
+	return EditedError{Paths: lost}
+}
+
+// keepUnselected copies the files in previous that belong to parts of the
+// plan that weren't selected into m, since they weren't generated but
+// aren't orphans either.
+func (m *Manifest) keepUnselected(previous *Manifest, selection *plan.Selection) {
+	for path, f := range previous.Files {
+		if _, ok := m.Files[path]; ok {
+			continue
+		}
+		if !generatedBy(path, selection) {
+			m.Files[path] = f
+		}
+	}
+}
+
+// generatedBy says if an apply of selection generates the file at path, or
+// would if it were still in the config.
+func generatedBy(path string, selection *plan.Selection) bool {
+	scope := scopeOf(path)
+	// repo files are always generated, and so are the files of an account
+	// or env itself, like its Makefile, when anything in it is selected
+	return scope == "repo" || selection.Contains(scope)
+}
+
+// scopeOf is the scope of the job that generates the file at path.
+func scopeOf(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	if len(parts) < 3 || parts[0] != rootPath {
+		return "repo"
+	}
+	switch parts[1] {
+	case "global":
+		return "global"
+	case "modules", "accounts", "envs":
+		if len(parts) > 4 && parts[1] != "modules" {
+			// a component
+			return strings.Join(parts[1:4], "/")
+		}
+		return strings.Join(parts[1:3], "/")
+	}
+	return "repo"
+}
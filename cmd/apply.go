@@ -1,18 +1,26 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/chanzuckerberg/fogg/apply"
 	"github.com/chanzuckerberg/fogg/templates"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	applyCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file.")
+	applyCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file. Defaults to fogg.json, then fogg.yml or fogg.yaml.")
 	applyCmd.Flags().BoolP("verbose", "v", false, "use this to turn on verbose output")
+	applyCmd.Flags().Bool("dry-run", false, "Report the files that would change, with diffs, without writing them.")
+	applyCmd.Flags().Bool("prune", false, "Remove files that fogg generated before but doesn't anymore.")
+	applyCmd.Flags().Bool("prune-user-files", false, "Let --prune remove directories with files from .touch and .create templates in them.")
+	applyCmd.Flags().Bool("force", false, "Overwrite generated files even if they were edited by hand.")
+	applyCmd.Flags().Int("parallelism", apply.DefaultParallelism, "How many accounts, envs and components to generate at once.")
+	applyCmd.Flags().StringArray("only", nil, "Only generate this account, env, component, module, global or plugins, like envs/staging/db. Can be a glob and given more than once.")
 	rootCmd.AddCommand(applyCmd)
 }
 
@@ -21,15 +29,21 @@ var applyCmd = &cobra.Command{
 	Short: "Apply model defined in fogg.json to the current tree.",
 	Long:  "This command will take the model defined in fogg.json, build a plan and generate the appropriate files from templates.",
 	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, e := cmd.Flags().GetBool("dry-run")
+		if e != nil {
+			log.Panic(e)
+		}
 		logLevel := log.InfoLevel
 		if debug { // debug overrides quiet
 			logLevel = log.DebugLevel
 		} else if quiet {
 			logLevel = log.FatalLevel
+		} else if dryRun {
+			// apply logs every file it would write, only print the report
+			logLevel = log.WarnLevel
 		}
 		log.SetLevel(logLevel)
 
-		var e error
 		// Set up fs
 		pwd, e := os.Getwd()
 		if e != nil {
@@ -42,7 +56,28 @@ var applyCmd = &cobra.Command{
 		if e != nil {
 			log.Panic(e)
 		}
-		configFile, e := cmd.Flags().GetString("config")
+		opts := apply.Options{}
+		opts.Prune, e = cmd.Flags().GetBool("prune")
+		if e != nil {
+			log.Panic(e)
+		}
+		opts.PruneUserFiles, e = cmd.Flags().GetBool("prune-user-files")
+		if e != nil {
+			log.Panic(e)
+		}
+		opts.Force, e = cmd.Flags().GetBool("force")
+		if e != nil {
+			log.Panic(e)
+		}
+		opts.Only, e = cmd.Flags().GetStringArray("only")
+		if e != nil {
+			log.Panic(e)
+		}
+		opts.Parallelism, e = cmd.Flags().GetInt("parallelism")
+		if e != nil {
+			log.Panic(e)
+		}
+		configFile, e := configFileFromFlags(cmd, fs)
 		if e != nil {
 			log.Panic(e)
 		}
@@ -54,8 +89,25 @@ var applyCmd = &cobra.Command{
 
 		exitOnConfigErrors(err)
 
+		if dryRun {
+			changes, e := apply.DryRun(fs, config, templates.Templates, opts)
+			if e != nil {
+				log.Fatal(e)
+			}
+			apply.PrintChanges(os.Stdout, changes)
+			return
+		}
+
 		// apply
-		e = apply.Apply(fs, config, templates.Templates)
+		e = apply.Apply(fs, config, templates.Templates, opts)
+		if edited, ok := errors.Cause(e).(apply.EditedError); ok {
+			fmt.Println("these generated files were edited since fogg wrote them, applying would overwrite them:")
+			for _, path := range edited.Paths {
+				fmt.Printf("  %s\n", path)
+			}
+			fmt.Println("move the changes into fogg.json or the templates, or run fogg apply --force to overwrite them")
+			os.Exit(1)
+		}
 		if e != nil {
 			log.Panic(e)
 		}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chanzuckerberg/fogg/apply"
+	"github.com/chanzuckerberg/fogg/templates"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	checkCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file. Defaults to fogg.json, then fogg.yml or fogg.yaml.")
+	checkCmd.Flags().BoolP("verbose", "v", false, "use this to turn on verbose output")
+	rootCmd.AddCommand(checkCmd)
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that the files fogg generates are up to date.",
+	Long:  "check generates the tree in memory, the same way apply does, and compares it to the files on disk. It exits non-zero listing the out of date files if fogg.json changed without running fogg apply or a generated file was edited by hand. Files from .touch and .create templates are left out. It makes no changes, which makes it useful in CI.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// apply logs every file it writes, only say what's wrong
+		logLevel := log.WarnLevel
+		if debug { // debug overrides quiet
+			logLevel = log.DebugLevel
+		} else if quiet {
+			logLevel = log.FatalLevel
+		}
+		log.SetLevel(logLevel)
+
+		var e error
+		// Set up fs
+		pwd, e := os.Getwd()
+		if e != nil {
+			log.Panic(e)
+		}
+		fs := afero.NewBasePathFs(afero.NewOsFs(), pwd)
+
+		// handle flags
+		verbose, e := cmd.Flags().GetBool("verbose")
+		if e != nil {
+			log.Panic(e)
+		}
+		configFile, e := configFileFromFlags(cmd, fs)
+		if e != nil {
+			log.Panic(e)
+		}
+
+		// check that we are at root of initialized git repo
+		openGitOrExit(pwd)
+
+		config, err := readAndValidateConfig(fs, configFile, verbose)
+
+		exitOnConfigErrors(err)
+
+		changes, e := apply.DryRun(fs, config, templates.Templates, apply.Options{})
+		if e != nil {
+			log.Fatal(e)
+		}
+		stale := apply.Stale(changes)
+		if len(stale) == 0 {
+			fmt.Println("generated files are up to date")
+			return
+		}
+		fmt.Println("generated files are out of date, run fogg apply:")
+		for _, change := range stale {
+			if change.Edited {
+				fmt.Printf("  %-9s %s (edited by hand)\n", change.Action, change.Path)
+			} else {
+				fmt.Printf("  %-9s %s\n", change.Action, change.Path)
+			}
+		}
+		if verbose {
+			for _, change := range stale {
+				fmt.Print(change.Diff)
+			}
+		}
+		os.Exit(1)
+	},
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/chanzuckerberg/fogg/plan"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	explainCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file. Defaults to fogg.json, then fogg.yml or fogg.yaml.")
+	rootCmd.AddCommand(explainCmd)
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain ADDRESS",
+	Short: "Show where each setting of an env, account or component comes from",
+	Long: `explain resolves the settings of one part of the config and prints each one with
+the level it was inherited from (defaults, account, env, component or global)
+and the file and key that set it. ADDRESS is one of global, accounts/<account>,
+accounts/<account>/<component>, envs/<env> or envs/<env>/<component>, for
+example fogg explain envs/staging/db.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pwd, e := os.Getwd()
+		if e != nil {
+			log.Panic(e)
+		}
+		fs := afero.NewBasePathFs(afero.NewOsFs(), pwd)
+
+		configFile, e := configFileFromFlags(cmd, fs)
+		if e != nil {
+			log.Panic(e)
+		}
+
+		config, err := readAndValidateConfig(fs, configFile, false)
+		exitOnConfigErrors(err)
+
+		p, e := plan.Eval(config, false)
+		if e != nil {
+			log.Panic(e)
+		}
+		explanations, e := plan.Explain(config, p, args[0])
+		if e != nil {
+			log.Fatal(e)
+		}
+		e = plan.PrintExplanations(os.Stdout, args[0], explanations)
+		if e != nil {
+			log.Panic(e)
+		}
+	},
+}
@@ -10,6 +10,7 @@ import (
 )
 
 func init() {
+	initCmd.Flags().StringP("config", "c", "fogg.json", "Write the config to this file. Use a .yml or .yaml extension for YAML.")
 	rootCmd.AddCommand(initCmd)
 }
 
@@ -25,10 +26,15 @@ var initCmd = &cobra.Command{
 		}
 		fs := afero.NewBasePathFs(afero.NewOsFs(), pwd)
 
+		configFile, e := cmd.Flags().GetString("config")
+		if e != nil {
+			log.Panic(e)
+		}
+
 		// check that we are at root of initialized git repo
 		openGitOrExit(pwd)
 
-		e = fogg_init.Init(fs)
+		e = fogg_init.Init(fs, configFile)
 		if e != nil {
 			log.Panic(e)
 		}
@@ -10,15 +10,17 @@ import (
 )
 
 func init() {
-	planCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file.")
+	planCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file. Defaults to fogg.json, then fogg.yml or fogg.yaml.")
 	planCmd.Flags().BoolP("verbose", "v", false, "use this to turn on verbose output")
+	planCmd.Flags().StringP("format", "f", plan.FormatText, "Output format, one of text, json or yaml.")
+	planCmd.Flags().StringArray("only", nil, "Only print this account, env, component, module, global or plugins, like envs/staging/db. Can be a glob and given more than once.")
 	rootCmd.AddCommand(planCmd)
 }
 
 var planCmd = &cobra.Command{
 	Use:   "plan",
 	Short: "Run a plan",
-	Long:  "plan will read fogg.json, use that to generate a plan and print that plan out. It will make no changes.",
+	Long:  "plan will read fogg.json, use that to generate a plan and print that plan out. It will make no changes. Use --format json or yaml to feed the plan to other tools, and --only to print part of it.",
 	Run: func(cmd *cobra.Command, args []string) {
 		logLevel := log.InfoLevel
 		if debug { // debug overrides quiet
@@ -41,8 +43,16 @@ var planCmd = &cobra.Command{
 		if e != nil {
 			log.Panic(e)
 		}
+		format, e := cmd.Flags().GetString("format")
+		if e != nil {
+			log.Panic(e)
+		}
+		only, e := cmd.Flags().GetStringArray("only")
+		if e != nil {
+			log.Panic(e)
+		}
 
-		configFile, e := cmd.Flags().GetString("config")
+		configFile, e := configFileFromFlags(cmd, fs)
 		if e != nil {
 			log.Panic(e)
 		}
@@ -58,9 +68,13 @@ var planCmd = &cobra.Command{
 		if e != nil {
 			log.Panic(e)
 		}
-		e = plan.Print(p)
+		selection, e := plan.Select(p, only)
 		if e != nil {
-			log.Panic(e)
+			log.Fatal(e)
+		}
+		e = plan.Print(os.Stdout, p, selection, format)
+		if e != nil {
+			log.Fatal(e)
 		}
 	},
 }
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/chanzuckerberg/fogg/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema for fogg.json",
+	Long:  "schema prints a JSON Schema (draft-07) for the fogg config, generated from fogg's own types. Point your editor at it to validate and autocomplete fogg.json or fogg.yml.",
+	Run: func(cmd *cobra.Command, args []string) {
+		s, e := config.Schema()
+		if e != nil {
+			log.Panic(e)
+		}
+		fmt.Println(string(s))
+	},
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chanzuckerberg/fogg/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	upgradeCmd.Flags().StringP("config", "c", "fogg.json", "Use this to override the fogg config file. Defaults to fogg.json, then fogg.yml or fogg.yaml.")
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade fogg.json to the current config version",
+	Long:  "upgrade rewrites fogg.json and the files in fogg.d in place, applying every migration between the version it declares and the newest version this fogg understands, then prints what changed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		pwd, e := os.Getwd()
+		if e != nil {
+			log.Panic(e)
+		}
+		fs := afero.NewBasePathFs(afero.NewOsFs(), pwd)
+
+		configFile, e := configFileFromFlags(cmd, fs)
+		if e != nil {
+			log.Panic(e)
+		}
+
+		// includes have their own versions, so each file is upgraded separately.
+		// They skip the migrations that change defaults, only the main file gets those.
+		files, e := config.ConfigFiles(fs, configFile)
+		if e != nil {
+			log.Panic(e)
+		}
+		for _, file := range files {
+			migrations, e := config.Upgrade(fs, file)
+			exitOnConfigErrors(e)
+
+			if len(migrations) == 0 {
+				fmt.Printf("%s is already at version %d\n", file, config.CurrentVersion)
+				continue
+			}
+			fmt.Printf("upgraded %s to version %d:\n", file, config.CurrentVersion)
+			for _, m := range migrations {
+				fmt.Printf("  %s\n", m)
+				if len(m.Paths) > 0 {
+					fmt.Printf("    %s\n", strings.Join(m.Paths, "\n    "))
+				}
+			}
+		}
+	},
+}
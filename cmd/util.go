@@ -3,13 +3,13 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/chanzuckerberg/fogg/config"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
-	validator "gopkg.in/go-playground/validator.v9"
+	"github.com/spf13/cobra"
 )
 
 func openGitOrExit(pwd string) {
@@ -24,32 +24,62 @@ func openGitOrExit(pwd string) {
 	}
 }
 
+// configFileFromFlags returns the config file passed with --config. When the
+// flag isn't set and fogg.json doesn't exist we look for a YAML config instead.
+func configFileFromFlags(cmd *cobra.Command, fs afero.Fs) (string, error) {
+	configFile, e := cmd.Flags().GetString("config")
+	if e != nil || cmd.Flags().Changed("config") {
+		return configFile, e
+	}
+	for _, f := range []string{configFile, "fogg.yml", "fogg.yaml"} {
+		if _, e := fs.Stat(f); e == nil {
+			return f, nil
+		}
+	}
+	return configFile, nil
+}
+
 func readAndValidateConfig(fs afero.Fs, configFile string, verbose bool) (*config.Config, error) {
-	config, err := config.FindAndReadConfig(fs, configFile)
-	if err != nil {
+	c, err := config.FindAndReadConfig(fs, configFile)
+	unknown, isUnknown := err.(config.UnknownFieldsError)
+	if err != nil && !isUnknown {
 		return nil, errors.Wrap(err, "unable to read config file")
 	}
 	if verbose {
 		log.Debug("CONFIG")
-		log.Debug("%#v\n=====", config)
+		log.Debugf("%#v\n=====", c)
 	}
 
-	err = config.Validate()
-	return config, err
+	err = c.Validate()
+	if isUnknown {
+		// report typos alongside validation errors, since a misspelled key
+		// is usually why a required one is missing
+		return c, multierror.Append(unknown, err)
+	}
+	return c, err
 }
 
 func exitOnConfigErrors(err error) {
 	if err != nil {
-		fmt.Println("fogg.json has error(s):")
-		errs, ok := err.(validator.ValidationErrors)
-		if ok {
-			for _, err := range errs {
-				msg := fmt.Sprintf("\t%s is a %s %s", err.Namespace(), err.Tag(), err.Kind())
-				fmt.Println(strings.Replace(msg, "Config.", "", 1))
-			}
-		} else {
-			log.Panic(err)
-		}
+		fmt.Println("fogg config has error(s):")
+		printConfigErrors(err)
 		os.Exit(1)
 	}
 }
+
+func printConfigErrors(err error) {
+	switch errs := errors.Cause(err).(type) {
+	case *multierror.Error:
+		for _, err := range errs.Errors {
+			printConfigErrors(err)
+		}
+	case config.UnknownFieldsError:
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+	case config.Error:
+		fmt.Println(errs)
+	default:
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// validAccountID matches aws account ids, which are always 12 digits
+var validAccountID = regexp.MustCompile(`^[0-9]{12}$`)
+
+var allDigits = regexp.MustCompile(`^[0-9]+$`)
+
+// stringifyAccountIDs rewrites account ids given as numbers into 12 digit
+// strings. It runs on every load, whatever the config's version, and as a
+// migration so that fogg upgrade writes the strings back out. Numbers lose leading zeros, so 012345678901 comes in as
+// 12345678901 and is padded back. YAML tags an unquoted 012345678901 as a
+// float, since it isn't valid octal, so any unquoted run of digits counts.
+func stringifyAccountIDs(root *yaml.Node) []string {
+	var changed []string
+	stringify := func(scope *yaml.Node, path string) {
+		id := mappingValue(scope, "account_id")
+		if id == nil || !isUnquotedDigits(id) {
+			return
+		}
+		id.Value = padAccountID(id.Value)
+		id.Tag = "!!str"
+		id.Style = yaml.DoubleQuotedStyle
+		changed = append(changed, joinPath(path, "account_id"))
+	}
+	each := func(scopes *yaml.Node, path string, f func(*yaml.Node, string)) {
+		for _, pair := range mappingPairs(scopes) {
+			f(pair[1], joinPath(path, pair[0].Value))
+		}
+	}
+
+	stringify(mappingValue(root, "defaults"), "defaults")
+	stringify(mappingValue(root, "global"), "global")
+	withComponents := func(scope *yaml.Node, path string) {
+		stringify(scope, path)
+		each(mappingValue(scope, "components"), joinPath(path, "components"), stringify)
+	}
+	each(mappingValue(root, "accounts"), "accounts", withComponents)
+	each(mappingValue(root, "envs"), "envs", withComponents)
+	return changed
+}
+
+// isUnquotedDigits reports whether n is a scalar made only of digits that
+// wasn't written as a string.
+func isUnquotedDigits(n *yaml.Node) bool {
+	if n.Kind != yaml.ScalarNode || n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 || n.ShortTag() == "!!str" {
+		return false
+	}
+	return allDigits.MatchString(n.Value)
+}
+
+func padAccountID(n string) string {
+	i, e := strconv.ParseInt(n, 10, 64)
+	if e != nil || i < 0 {
+		// leave it for validation to complain about
+		return n
+	}
+	return fmt.Sprintf("%012d", i)
+}
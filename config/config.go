@@ -2,7 +2,6 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
@@ -13,70 +12,92 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 	"gopkg.in/go-playground/validator.v9"
+	yaml "gopkg.in/yaml.v3"
 )
 
 type defaults struct {
-	AccountID          *int64            `json:"account_id,omitempty"`
-	AWSProfileBackend  string            `json:"aws_profile_backend" validate:"required"`
-	AWSProfileProvider string            `json:"aws_profile_provider" validate:"required"`
-	AWSProviderVersion string            `json:"aws_provider_version" validate:"required"`
-	AWSRegionBackend   string            `json:"aws_region_backend" validate:"required"`
-	AWSRegionProvider  string            `json:"aws_region_provider" validate:"required"`
-	AWSRegions         []string          `json:"aws_regions,omitempty"`
-	ExtraVars          map[string]string `json:"extra_vars"`
-	InfraBucket        string            `json:"infra_s3_bucket" validate:"required"`
-	Owner              string            `json:"owner" validate:"required"`
-	Project            string            `json:"project" validate:"required"`
-	TerraformVersion   string            `json:"terraform_version" validate:"required"`
+	AccountID           *string             `json:"account_id,omitempty"`
+	AWSProfileBackend   string              `json:"aws_profile_backend" validate:"required"`
+	AWSProfileProvider  string              `json:"aws_profile_provider" validate:"required"`
+	AWSProviderVersion  string              `json:"aws_provider_version" validate:"required"`
+	AWSRegionBackend    string              `json:"aws_region_backend" validate:"required"`
+	AWSRegionProvider   string              `json:"aws_region_provider" validate:"required"`
+	AWSRegions          []string            `json:"aws_regions,omitempty"`
+	ExtraVars           map[string]ExtraVar `json:"extra_vars"`
+	InfraBucket         string              `json:"infra_s3_bucket" validate:"required"`
+	Owner               string              `json:"owner" validate:"required"`
+	Project             string              `json:"project" validate:"required"`
+	RemoteStateSiblings bool                `json:"remote_state_siblings,omitempty"`
+	TerraformVersion    string              `json:"terraform_version" validate:"required"`
 }
 
 type Account struct {
-	AccountID          *int64            `json:"account_id"`
-	AWSProfileBackend  *string           `json:"aws_profile_backend"`
-	AWSProfileProvider *string           `json:"aws_profile_provider"`
-	AWSProviderVersion *string           `json:"aws_provider_version,omitempty"`
-	AWSRegionBackend   *string           `json:"aws_region_backend"`
-	AWSRegionProvider  *string           `json:"aws_region_provider"`
-	AWSRegions         []string          `json:"aws_regions"`
-	ExtraVars          map[string]string `json:"extra_vars,omitempty"`
-	InfraBucket        *string           `json:"infra_s3_bucket"`
-	Owner              *string           `json:"owner"`
-	Project            *string           `json:"project"`
-	TerraformVersion   *string           `json:"terraform_version"`
+	AccountID           *string             `json:"account_id"`
+	AWSProfileBackend   *string             `json:"aws_profile_backend"`
+	AWSProfileProvider  *string             `json:"aws_profile_provider"`
+	AWSProviderVersion  *string             `json:"aws_provider_version,omitempty"`
+	AWSRegionBackend    *string             `json:"aws_region_backend"`
+	AWSRegionProvider   *string             `json:"aws_region_provider"`
+	AWSRegions          []string            `json:"aws_regions"`
+	ExtraVars           map[string]ExtraVar `json:"extra_vars,omitempty"`
+	InfraBucket         *string             `json:"infra_s3_bucket"`
+	Owner               *string             `json:"owner"`
+	Project             *string             `json:"project"`
+	RemoteStateSiblings *bool               `json:"remote_state_siblings,omitempty"`
+	TerraformVersion    *string             `json:"terraform_version"`
+
+	Components map[string]*Component `json:"components,omitempty"`
 }
 
+// Env is an environment like staging or prod. Account names the account it
+// lives in, whose settings it inherits. Extends names an env to inherit
+// settings and components from; Abstract envs only exist to be extended and
+// aren't generated.
 type Env struct {
-	AccountID          *int64            `json:"account_id"`
-	AWSProfileBackend  *string           `json:"aws_profile_backend"`
-	AWSProfileProvider *string           `json:"aws_profile_provider"`
-	AWSProviderVersion *string           `json:"aws_provider_version,omitempty"`
-	AWSRegionBackend   *string           `json:"aws_region_backend"`
-	AWSRegionProvider  *string           `json:"aws_region_provider"`
-	AWSRegions         []string          `json:"aws_regions"`
-	ExtraVars          map[string]string `json:"extra_vars,omitempty"`
-	InfraBucket        *string           `json:"infra_s3_bucket"`
-	Owner              *string           `json:"owner"`
-	Project            *string           `json:"project"`
-	TerraformVersion   *string           `json:"terraform_version"`
-	Type               *string           `json:"type"`
+	Abstract            bool                `json:"abstract,omitempty"`
+	Account             *string             `json:"account,omitempty"`
+	AccountID           *string             `json:"account_id"`
+	AWSProfileBackend   *string             `json:"aws_profile_backend"`
+	AWSProfileProvider  *string             `json:"aws_profile_provider"`
+	AWSProviderVersion  *string             `json:"aws_provider_version,omitempty"`
+	AWSRegionBackend    *string             `json:"aws_region_backend"`
+	AWSRegionProvider   *string             `json:"aws_region_provider"`
+	AWSRegions          []string            `json:"aws_regions"`
+	Extends             *string             `json:"extends,omitempty"`
+	ExtraVars           map[string]ExtraVar `json:"extra_vars,omitempty"`
+	InfraBucket         *string             `json:"infra_s3_bucket"`
+	Owner               *string             `json:"owner"`
+	Project             *string             `json:"project"`
+	RemoteStateSiblings *bool               `json:"remote_state_siblings,omitempty"`
+	TerraformVersion    *string             `json:"terraform_version"`
+	Type                *string             `json:"type"`
 
 	Components map[string]*Component `json:"components"`
 }
 
+// Component is a terraform root module in an env or account. Account
+// overrides the account of its env. DependsOn lists what it needs remote
+// state for: components next to it, envs/<env>/<component>,
+// accounts/<account> or accounts/<account>/<component>. With
+// RemoteStateSiblings, set at any level, it gets remote state for every
+// component next to it as well.
 type Component struct {
-	AccountID          *int64            `json:"account_id"`
-	AWSProfileBackend  *string           `json:"aws_profile_backend"`
-	AWSProfileProvider *string           `json:"aws_profile_provider"`
-	AWSProviderVersion *string           `json:"aws_provider_version,omitempty"`
-	AWSRegionBackend   *string           `json:"aws_region_backend"`
-	AWSRegionProvider  *string           `json:"aws_region_provider"`
-	AWSRegions         []string          `json:"aws_regions"`
-	ExtraVars          map[string]string `json:"extra_vars,omitempty"`
-	InfraBucket        *string           `json:"infra_s3_bucket"`
-	ModuleSource       *string           `json:"module_source"`
-	Owner              *string           `json:"owner"`
-	Project            *string           `json:"project"`
-	TerraformVersion   *string           `json:"terraform_version"`
+	Account             *string             `json:"account,omitempty"`
+	AccountID           *string             `json:"account_id"`
+	AWSProfileBackend   *string             `json:"aws_profile_backend"`
+	AWSProfileProvider  *string             `json:"aws_profile_provider"`
+	AWSProviderVersion  *string             `json:"aws_provider_version,omitempty"`
+	AWSRegionBackend    *string             `json:"aws_region_backend"`
+	AWSRegionProvider   *string             `json:"aws_region_provider"`
+	AWSRegions          []string            `json:"aws_regions"`
+	DependsOn           []string            `json:"depends_on,omitempty"`
+	ExtraVars           map[string]ExtraVar `json:"extra_vars,omitempty"`
+	InfraBucket         *string             `json:"infra_s3_bucket"`
+	ModuleSource        *string             `json:"module_source"`
+	Owner               *string             `json:"owner"`
+	Project             *string             `json:"project"`
+	RemoteStateSiblings *bool               `json:"remote_state_siblings,omitempty"`
+	TerraformVersion    *string             `json:"terraform_version"`
 }
 
 // Plugins contains configuration around plugins
@@ -91,11 +112,18 @@ type Module struct {
 }
 
 type Config struct {
+	// Version is the config format version, see CurrentVersion
+	Version  int                `json:"version"`
 	Accounts map[string]Account `json:"accounts"`
 	Defaults defaults           `json:"defaults"`
 	Envs     map[string]Env     `json:"envs"`
+	Global   *Component         `json:"global,omitempty"`
 	Modules  map[string]Module  `json:"modules"`
 	Plugins  Plugins            `json:"plugins"`
+
+	// where the config was read from, used for validation and error messages
+	fs        afero.Fs
+	positions *positions
 }
 
 var allRegions = []string{
@@ -118,13 +146,14 @@ var allRegions = []string{
 
 func InitConfig(project, region, bucket, awsProfile, owner, awsProviderVersion string) *Config {
 	return &Config{
+		Version: CurrentVersion,
 		Defaults: defaults{
 			AWSProfileBackend:  awsProfile,
 			AWSProfileProvider: awsProfile,
 			AWSProviderVersion: awsProviderVersion,
 			AWSRegionBackend:   region,
 			AWSRegionProvider:  region,
-			ExtraVars:          map[string]string{},
+			ExtraVars:          map[string]ExtraVar{},
 			InfraBucket:        bucket,
 			Owner:              owner,
 			Project:            project,
@@ -136,36 +165,145 @@ func InitConfig(project, region, bucket, awsProfile, owner, awsProviderVersion s
 	}
 }
 
+// ReadConfig reads a config in either JSON or YAML, determining which by
+// looking at the contents. Keys that don't match any setting result in an
+// UnknownFieldsError; the config is still returned alongside it so that
+// callers can report validation errors at the same time.
 func ReadConfig(f io.Reader) (*Config, error) {
-	c := &Config{}
 	b, e := ioutil.ReadAll(f)
 	if e != nil {
 		return nil, errors.Wrap(e, "unable to read config")
 	}
+	return readConfig(b, sniffFormat(b), "")
+}
+
+func readConfig(b []byte, format Format, filename string) (*Config, error) {
+	doc, p, e := loadDocument(b, format, filename, false)
+	if e != nil {
+		return nil, e
+	}
+	return decodeConfig(doc, p, format)
+}
+
+// loadDocument parses a config file and brings it up to CurrentVersion.
+func loadDocument(b []byte, format Format, filename string, include bool) (*yaml.Node, *positions, error) {
+	doc, e := parseDocument(b, format)
+	if e != nil {
+		return nil, nil, errors.Wrapf(parseError(e, b, format, &positions{filename: filename}), "unable to parse %s config file", format)
+	}
+	p := nodePositions(doc, filename)
+
+	// older configs are upgraded in memory, fogg upgrade writes the result
+	_, e = migrate(doc, p, include)
+	if e != nil {
+		return nil, nil, e
+	}
+	stringifyAccountIDs(documentRoot(doc))
+	return doc, p, nil
+}
+
+func decodeConfig(doc *yaml.Node, p *positions, format Format) (*Config, error) {
+	b, e := documentJSON(doc)
+	if e != nil {
+		return nil, errors.Wrapf(e, "unable to parse %s config file", format)
+	}
+	var generic interface{}
+	e = json.Unmarshal(b, &generic)
+	if e != nil {
+		return nil, errors.Wrapf(e, "unable to parse %s config file", format)
+	}
+
+	c := &Config{positions: p}
 	e = json.Unmarshal(b, c)
 	if e != nil {
-		return nil, errors.Wrap(e, "unable to parse json config file")
+		return nil, errors.Wrapf(parseError(e, b, format, p), "unable to parse %s config file", format)
+	}
+
+	unknown := findUnknownFields(generic)
+	if len(unknown) > 0 {
+		for i := range unknown {
+			unknown[i].Pos = p.lookup(unknown[i].Path)
+		}
+		return c, UnknownFieldsError(unknown)
 	}
 	return c, nil
 }
 
-func FindAndReadConfig(fs afero.Fs, configFile string) (*Config, error) {
+// readFile reads a config file, taking the format from the file extension and
+// falling back to sniffing the contents.
+func readFile(fs afero.Fs, configFile string) ([]byte, Format, error) {
 	f, e := fs.Open(configFile)
 	if e != nil {
-		return nil, errors.Wrap(e, "unable to open config file")
+		return nil, "", errors.Wrap(e, "unable to open config file")
+	}
+	defer f.Close()
+	b, e := ioutil.ReadAll(f)
+	if e != nil {
+		return nil, "", errors.Wrap(e, "unable to read config")
+	}
+	format, ok := FormatFromPath(configFile)
+	if !ok {
+		format = sniffFormat(b)
+	}
+	return b, format, nil
+}
+
+// FindAndReadConfig reads the config at configFile along with the files in
+// IncludeDir next to it, merging them into a single config.
+func FindAndReadConfig(fs afero.Fs, configFile string) (*Config, error) {
+	files, e := ConfigFiles(fs, configFile)
+	if e != nil {
+		return nil, errors.Wrapf(e, "unable to list %s", IncludeDir)
+	}
+
+	var doc *yaml.Node
+	var p *positions
+	var format Format
+	var errs *multierror.Error
+	for i, file := range files {
+		b, fileFormat, e := readFile(fs, file)
+		if e != nil {
+			return nil, e
+		}
+		fileDoc, fileP, e := loadDocument(b, fileFormat, file, i > 0)
+		if e != nil {
+			return nil, e
+		}
+		if i == 0 {
+			doc, p, format = fileDoc, fileP, fileFormat
+			continue
+		}
+		errs = multierror.Append(errs, mergeDocuments(doc, fileDoc, p, fileP)...)
+	}
+	if errs.ErrorOrNil() != nil {
+		sortErrors(errs.Errors)
+		return nil, errs
 	}
-	reader := io.ReadCloser(f)
-	defer reader.Close()
-	c, err2 := ReadConfig(reader)
-	return c, err2
+
+	c, e := decodeConfig(doc, p, format)
+	if c != nil {
+		c.fs = fs
+	}
+	return c, e
 }
 
-// Validate validates the config
-func (c *Config) Validate() error {
-	err := c.validateExtraVars()
-	if err != nil {
-		return err
+// Marshal serializes the config in the given format.
+func (c *Config) Marshal(format Format) ([]byte, error) {
+	b, e := json.Marshal(c)
+	if e != nil {
+		return nil, errors.Wrap(e, "unable to marshal json")
+	}
+	doc, e := parseDocument(b, FormatJSON)
+	if e != nil {
+		return nil, errors.Wrap(e, "unable to marshal config")
 	}
+	return encodeDocument(doc, format)
+}
+
+// Validate validates the config. All problems are collected, each tied to
+// its position in the config file.
+func (c *Config) Validate() error {
+	var errs *multierror.Error
 
 	v := validator.New()
 	// https://github.com/go-playground/validator/issues/323#issuecomment-343670840
@@ -177,31 +315,51 @@ func (c *Config) Validate() error {
 		}
 		return name
 	})
-	return v.Struct(c)
+	errs = multierror.Append(errs, c.Locate(v.Struct(c)))
+	errs = multierror.Append(errs, c.validateExtraVars()...)
+	errs = multierror.Append(errs, c.validateRegions()...)
+	errs = multierror.Append(errs, c.validateAccountIDs()...)
+	errs = multierror.Append(errs, c.validateAccountReferences()...)
+	errs = multierror.Append(errs, c.validateExtends()...)
+	errs = multierror.Append(errs, c.validateNames()...)
+	errs = multierror.Append(errs, c.validateModuleSources()...)
+	if len(errs.Errors) == 0 {
+		return nil
+	}
+	sortErrors(errs.Errors)
+	return errs
 }
 
-// validateExtraVars make sure users don't specify reserved variables
-func (c *Config) validateExtraVars() error {
-	var err *multierror.Error
-	validate := func(extraVars map[string]string) {
-		for extraVar := range extraVars {
+// validateExtraVars make sure users don't specify reserved variables and that
+// values can be written as terraform literals
+func (c *Config) validateExtraVars() []error {
+	var errs []error
+	validate := func(path string, extraVars map[string]ExtraVar) {
+		for extraVar, v := range extraVars {
 			if _, ok := reservedVariableNames[extraVar]; ok {
-				err = multierror.Append(err, fmt.Errorf("extra_var[%s] is a fogg reserved variable name", extraVar))
+				errs = append(errs, c.errorf(path+"."+extraVar, "is a fogg reserved variable name"))
+			}
+			if v.Default == nil {
+				// no default
+				continue
+			}
+			for _, nullPath := range nullPaths(v.Default, path+"."+extraVar) {
+				errs = append(errs, c.errorf(nullPath, "can't be null"))
 			}
-		}
-		return
-	}
-	extraVars := []map[string]string{}
-	extraVars = append(extraVars, c.Defaults.ExtraVars)
-	for _, env := range c.Envs {
-		extraVars = append(extraVars, env.ExtraVars)
-		for _, component := range env.Components {
-			extraVars = append(extraVars, component.ExtraVars)
 		}
 	}
-	for _, extraVar := range extraVars {
-		validate(extraVar)
+	validate("defaults.extra_vars", c.Defaults.ExtraVars)
+	if c.Global != nil {
+		validate("global.extra_vars", c.Global.ExtraVars)
 	}
-
-	return errors.Wrap(err.ErrorOrNil(), "extra_vars contains reserved variable names")
+	for name, account := range c.Accounts {
+		validate("accounts."+name+".extra_vars", account.ExtraVars)
+	}
+	for envName, env := range c.Envs {
+		validate("envs."+envName+".extra_vars", env.ExtraVars)
+	}
+	c.eachComponent(func(path string, component *Component) {
+		validate(path+".extra_vars", component.ExtraVars)
+	})
+	return errs
 }
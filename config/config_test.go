@@ -2,13 +2,18 @@ package config
 
 import (
 	"bufio"
+	"bytes"
+	jsonlib "encoding/json"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
-	validator "gopkg.in/go-playground/validator.v9"
 )
 
 func TestParseDefaults(t *testing.T) {
@@ -45,7 +50,7 @@ func TestParse(t *testing.T) {
 	c, e := ReadConfig(r)
 	assert.Nil(t, e)
 	assert.NotNil(t, c.Defaults)
-	assert.Equal(t, int64(1), *c.Defaults.AccountID)
+	assert.Equal(t, "000000000001", *c.Defaults.AccountID)
 	assert.Equal(t, "us-west-2", c.Defaults.AWSRegionBackend)
 	assert.Equal(t, "us-west-1", c.Defaults.AWSRegionProvider)
 	assert.Equal(t, "0.1.0", c.Defaults.AWSProviderVersion)
@@ -90,12 +95,12 @@ func TestValidation(t *testing.T) {
 	e = c.Validate()
 	assert.NotNil(t, e)
 
-	_, ok := e.(*validator.InvalidValidationError)
-	assert.False(t, ok)
-
-	err, ok := e.(validator.ValidationErrors)
+	err, ok := e.(*multierror.Error)
 	assert.True(t, ok)
-	assert.Len(t, err, 9)
+	assert.Len(t, err.Errors, 9)
+	for _, e := range err.Errors {
+		assert.IsType(t, Error{}, e)
+	}
 }
 
 func TestExtraVarsValidation(t *testing.T) {
@@ -121,12 +126,71 @@ func TestExtraVarsValidation(t *testing.T) {
 	e = c.Validate()
 	assert.Nil(t, e)
 
-	c.Defaults.ExtraVars = map[string]string{}
-	c.Defaults.ExtraVars["env"] = "failme"
+	c.Defaults.ExtraVars = map[string]ExtraVar{}
+	c.Defaults.ExtraVars["env"] = ExtraVar{Default: "failme"}
+	e = c.Validate()
+	assert.NotNil(t, e)
+
+	// components under accounts get an account variable
+	c.Defaults.ExtraVars = map[string]ExtraVar{"account": {Default: "failme"}}
 	e = c.Validate()
 	assert.NotNil(t, e)
 }
 
+func TestTypedExtraVars(t *testing.T) {
+	json := `
+	{
+		"defaults": {
+			"aws_region_backend": "us-west-2",
+			"aws_region_provider": "us-west-1",
+			"aws_profile_backend": "czi",
+			"aws_profile_provider": "czi",
+			"aws_provider_version": "czi",
+			"infra_s3_bucket": "the-bucket",
+			"project": "test-project",
+			"owner": "test@test.com",
+			"terraform_version": "0.11.0",
+			"extra_vars": {
+				"name": "db",
+				"count": 3,
+				"enabled": true,
+				"cidrs": ["10.0.0.0/16", "10.1.0.0/16"],
+				"labels": {"default": {"team": "infra", "default": "yes"}},
+				"size": {"default": "large", "description": "instance size"},
+				"password": {"default": null, "description": "the password"},
+				"wrapped": {"default": {"default": "x"}}
+			}
+		}
+	}`
+	c, e := ReadConfig(strings.NewReader(json))
+	assert.Nil(t, e)
+	assert.Nil(t, c.Validate())
+
+	vars := c.Defaults.ExtraVars
+	assert.Equal(t, ExtraVar{Default: "db"}, vars["name"])
+	assert.Equal(t, ExtraVar{Default: jsonlib.Number("3")}, vars["count"])
+	assert.Equal(t, ExtraVar{Default: true}, vars["enabled"])
+	assert.Equal(t, ExtraVar{Default: []interface{}{"10.0.0.0/16", "10.1.0.0/16"}}, vars["cidrs"])
+	assert.Equal(t, ExtraVar{Default: map[string]interface{}{"team": "infra", "default": "yes"}}, vars["labels"])
+	assert.Equal(t, ExtraVar{Default: "large", Description: "instance size"}, vars["size"])
+	assert.Equal(t, ExtraVar{Description: "the password"}, vars["password"])
+	assert.False(t, vars["password"].HasDefault())
+	assert.Equal(t, ExtraVar{Default: map[string]interface{}{"default": "x"}}, vars["wrapped"])
+
+	// they survive a round trip
+	b, e := c.Marshal(FormatJSON)
+	assert.Nil(t, e)
+	c2, e := ReadConfig(bytes.NewReader(b))
+	assert.Nil(t, e)
+	assert.Equal(t, vars, c2.Defaults.ExtraVars)
+
+	// terraform has no nulls
+	vars["cidrs"] = ExtraVar{Default: []interface{}{"10.0.0.0/16", nil}}
+	e = c.Validate()
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "defaults.extra_vars.cidrs.1 can't be null")
+}
+
 func TestInitConfig(t *testing.T) {
 	c := InitConfig("proj", "reg", "buck", "prof", "me@foo.example", "0.99.0")
 	assert.Equal(t, "prof", c.Defaults.AWSProfileBackend)
@@ -139,3 +203,515 @@ func TestInitConfig(t *testing.T) {
 	assert.Equal(t, "proj", c.Defaults.Project)
 	assert.Equal(t, "0.11.7", c.Defaults.TerraformVersion)
 }
+
+func TestParseYAML(t *testing.T) {
+	yml := `
+# comments are allowed in yaml
+defaults:
+  aws_region_backend: us-west-2
+  aws_region_provider: us-west-1
+  aws_profile_backend: czi
+  aws_profile_provider: czi
+  infra_s3_bucket: the-bucket
+  project: test-project
+  terraform_version: 0.11.0
+envs:
+  stage:
+    components:
+      env:
+        module_source: github.com/foo/bar
+`
+	r := ioutil.NopCloser(strings.NewReader(yml))
+	defer r.Close()
+	c, e := ReadConfig(r)
+	assert.Nil(t, e)
+	assert.Equal(t, "us-west-2", c.Defaults.AWSRegionBackend)
+	assert.Equal(t, "0.11.0", c.Defaults.TerraformVersion)
+	assert.Equal(t, "github.com/foo/bar", *c.Envs["stage"].Components["env"].ModuleSource)
+}
+
+func TestFindAndReadConfigFormats(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, e := ReadConfig(f)
+	assert.Nil(t, e)
+
+	for _, name := range []string{"fogg.json", "fogg.yml", "fogg.yaml", "fogg.conf"} {
+		t.Run(name, func(t *testing.T) {
+			format, ok := FormatFromPath(name)
+			if !ok {
+				format = FormatYAML
+			}
+			b, e := c.Marshal(format)
+			assert.Nil(t, e)
+			assert.Nil(t, afero.WriteFile(fs, name, b, 0644))
+
+			read, e := FindAndReadConfig(fs, name)
+			assert.Nil(t, e)
+			assert.Equal(t, name, read.positions.filename)
+			read.positions = c.positions
+			read.fs = nil
+			assert.Equal(t, c, read)
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	json := `
+	{
+		"defaults": {
+			"aws_region": "us-west-2",
+			"aws_profile": "czi",
+			"infra_bucket": "the-bucket",
+			"project": "test-project"
+		},
+		"envs": {
+			"staging": {
+				"components": {
+					"db": {
+						"modul_source": "github.com/foo/bar",
+						"zzz": "zzz",
+						"extra_vars": {"size": {"default": 1, "descripton": "x"}}
+					}
+				}
+			}
+		},
+		"plugins": {
+			"custom_plugins": {
+				"foo": {"url": "https://example.com", "formt": "tar"}
+			}
+		}
+	}`
+	r := ioutil.NopCloser(strings.NewReader(json))
+	defer r.Close()
+	c, e := ReadConfig(r)
+	assert.NotNil(t, c)
+	assert.Equal(t, "test-project", c.Defaults.Project)
+
+	unknown, ok := e.(UnknownFieldsError)
+	assert.True(t, ok)
+	assert.Equal(t, UnknownFieldsError{
+		{Pos: Pos{Line: 5, Column: 4}, Path: "defaults.aws_profile", Suggestion: "aws_profile_backend"},
+		{Pos: Pos{Line: 4, Column: 4}, Path: "defaults.aws_region", Suggestion: "aws_regions"},
+		{Pos: Pos{Line: 6, Column: 4}, Path: "defaults.infra_bucket", Suggestion: "infra_s3_bucket"},
+		{Pos: Pos{Line: 15, Column: 45}, Path: "envs.staging.components.db.extra_vars.size.descripton", Suggestion: "description"},
+		{Pos: Pos{Line: 13, Column: 7}, Path: "envs.staging.components.db.modul_source", Suggestion: "module_source"},
+		{Pos: Pos{Line: 14, Column: 7}, Path: "envs.staging.components.db.zzz", Suggestion: ""},
+		{Pos: Pos{Line: 22, Column: 43}, Path: "plugins.custom_plugins.foo.formt", Suggestion: "format"},
+	}, unknown)
+	assert.Equal(t, "6:4: defaults.infra_bucket is not a valid key, did you mean infra_s3_bucket?", unknown[2].Error())
+}
+
+func TestErrorPositions(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	data := []struct {
+		file     string
+		contents string
+		expected string
+	}{
+		{"fogg.json", "{\n  \"defaults\": {\n    \"owner\": }\n}", "fogg.json:3:14: "},
+		{"fogg.json", "{\n  \"envs\": {\n    \"staging\": {\"aws_regions\": \"foo\"}\n  }\n}", "fogg.json:3:17: envs.staging.aws_regions cannot be a string, expected []string"},
+		{"fogg.yml", "envs:\n  staging:\n    aws_regions: foo\n", "fogg.yml:3:5: envs.staging.aws_regions cannot be a string, expected []string"},
+		{"fogg.yml", "envs:\n  staging: [\n", "fogg.yml:2: did not find expected node content"},
+	}
+	for _, test := range data {
+		t.Run(test.expected, func(t *testing.T) {
+			assert.Nil(t, afero.WriteFile(fs, test.file, []byte(test.contents), 0644))
+			_, e := FindAndReadConfig(fs, test.file)
+			assert.NotNil(t, e)
+			cause, ok := errors.Cause(e).(Error)
+			assert.True(t, ok)
+			assert.True(t, strings.HasPrefix(cause.Error(), test.expected), cause.Error())
+		})
+	}
+}
+
+func TestValidationErrorPositions(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	json := `{
+  "defaults": {
+    "project": "test-project"
+  }
+}`
+	assert.Nil(t, afero.WriteFile(fs, "fogg.json", []byte(json), 0644))
+	c, e := FindAndReadConfig(fs, "fogg.json")
+	assert.Nil(t, e)
+
+	e = c.Validate()
+	errs, ok := e.(*multierror.Error)
+	assert.True(t, ok)
+	assert.Len(t, errs.Errors, 8)
+	assert.Equal(t, "fogg.json:2:3: defaults.aws_profile_backend is required", errs.Errors[0].Error())
+}
+
+func TestNamespacePath(t *testing.T) {
+	assert.Equal(t, "defaults.owner", namespacePath("Config.defaults.owner"))
+	assert.Equal(t, "envs.staging.components.db.owner", namespacePath("Config.envs[staging].components[db].owner"))
+}
+
+func TestSemanticValidation(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, fs.MkdirAll("terraform/modules/real", 0755))
+	json := `{
+  "defaults": {
+    "aws_region_backend": "us-west-2",
+    "aws_region_provider": "us-west-1",
+    "aws_regions": ["us-east-1", "mars-north-1"],
+    "aws_profile_backend": "czi",
+    "aws_profile_provider": "czi",
+    "aws_provider_version": "1.27.0",
+    "infra_s3_bucket": "the-bucket",
+    "project": "test-project",
+    "owner": "test@test.com",
+    "terraform_version": "0.11.0"
+  },
+  "accounts": {
+    "foo": {"account_id": "012345678901"},
+    "bar": {"account_id": 12345678901, "aws_region_backend": "us-west-9"}
+  },
+  "envs": {
+    "my env": {"account_id": "1234"},
+    "staging": {
+      "components": {
+        "real": {"module_source": "terraform/modules/real"},
+        "fake": {"module_source": "terraform/modules/fake"},
+        "remote": {"module_source": "github.com/foo/bar"},
+        "1db": {"extra_vars": {"owner": "me"}}
+      }
+    }
+  }
+}`
+	assert.Nil(t, afero.WriteFile(fs, "fogg.json", []byte(json), 0644))
+	c, e := FindAndReadConfig(fs, "fogg.json")
+	assert.Nil(t, e)
+
+	e = c.Validate()
+	errs, ok := e.(*multierror.Error)
+	assert.True(t, ok)
+
+	msgs := []string{}
+	for _, err := range errs.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		`fogg.json:5:34: defaults.aws_regions.1 "mars-north-1" is not a known aws region`,
+		`fogg.json:16:13: accounts.bar.account_id 012345678901 is also used by account foo`,
+		`fogg.json:16:40: accounts.bar.aws_region_backend "us-west-9" is not a known aws region`,
+		`fogg.json:19:5: envs.my env is not a valid name, use letters, numbers, - and _ and start with a letter or _`,
+		`fogg.json:19:16: envs.my env.account_id "1234" is not a 12 digit aws account id`,
+		`fogg.json:23:18: envs.staging.components.fake.module_source terraform/modules/fake does not exist`,
+		`fogg.json:25:9: envs.staging.components.1db is not a valid name, use letters, numbers, - and _ and start with a letter or _`,
+		`fogg.json:25:32: envs.staging.components.1db.extra_vars.owner is a fogg reserved variable name`,
+	}, msgs)
+}
+
+func TestAccountReferences(t *testing.T) {
+	json := `{
+  "defaults": {
+    "aws_profile_backend": "prof",
+    "aws_profile_provider": "prof",
+    "aws_provider_version": "1.27.0",
+    "aws_region_backend": "us-west-2",
+    "aws_region_provider": "us-west-2",
+    "infra_s3_bucket": "bucket",
+    "owner": "foo@example.com",
+    "project": "foo",
+    "terraform_version": "0.11.0"
+  },
+  "accounts": {
+    "prod": {"components": {"iam": {"account": "prod"}}}
+  },
+  "global": {"account": "prod"},
+  "envs": {
+    "prod": {"account": "prod", "components": {"db": {"account": "staging"}}},
+    "staging": {"account": "stagign"}
+  }
+}`
+	c, e := ReadConfig(strings.NewReader(json))
+	assert.Nil(t, e)
+	e = c.Validate()
+	assert.NotNil(t, e)
+	var msgs []string
+	for _, err := range e.(*multierror.Error).Errors {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		`14:37: accounts.prod.components.iam.account can't be set, the component belongs to account prod`,
+		`18:55: envs.prod.components.db.account staging is not a defined account`,
+		`19:17: envs.staging.account stagign is not a defined account`,
+	}, msgs)
+}
+
+func TestValidateExtends(t *testing.T) {
+	json := `{
+  "envs": {
+    "a": {"extends": "b"},
+    "b": {"extends": "a"},
+    "c": {"extends": "a"},
+    "d": {"extends": "nope"},
+    "e": {"extends": "e"}
+  }
+}`
+	c, e := ReadConfig(strings.NewReader(json))
+	assert.Nil(t, e)
+	assert.Equal(t, []string{
+		`3:11: envs.a.extends creates a cycle: a -> b -> a`,
+		`4:11: envs.b.extends creates a cycle: b -> a -> b`,
+		`6:11: envs.d.extends nope is not a defined env`,
+		`7:11: envs.e.extends creates a cycle: e -> e`,
+	}, errorStrings(c.validateExtends()))
+}
+
+func TestMigrateAccountIDs(t *testing.T) {
+	json := `
+	{
+		"defaults": {"account_id": 1},
+		"accounts": {"foo": {"account_id": 12345678901}, "bar": {"account_id": "012345678902"}},
+		"envs": {"staging": {"account_id": 3, "components": {"db": {"account_id": 4, "extra_vars": {"account_id": "5"}}}}}
+	}`
+	c, e := ReadConfig(strings.NewReader(json))
+	assert.Nil(t, e)
+	assert.Equal(t, "000000000001", *c.Defaults.AccountID)
+	assert.Equal(t, "012345678901", *c.Accounts["foo"].AccountID)
+	assert.Equal(t, "012345678902", *c.Accounts["bar"].AccountID)
+	assert.Equal(t, "000000000003", *c.Envs["staging"].AccountID)
+	assert.Equal(t, "000000000004", *c.Envs["staging"].Components["db"].AccountID)
+	assert.Equal(t, "5", c.Envs["staging"].Components["db"].ExtraVars["account_id"].Default)
+}
+
+func TestMigrateAccountIDsYAML(t *testing.T) {
+	yml := `
+defaults:
+  account_id: 012345678901
+accounts:
+  foo:
+    account_id: 12345678902
+  bar:
+    account_id: "012345678903"
+`
+	c, e := ReadConfig(strings.NewReader(yml))
+	assert.Nil(t, e)
+	assert.Equal(t, "012345678901", *c.Defaults.AccountID)
+	assert.Equal(t, "012345678902", *c.Accounts["foo"].AccountID)
+	assert.Equal(t, "012345678903", *c.Accounts["bar"].AccountID)
+
+	c, e = ReadConfig(strings.NewReader("version: 3\nglobal:\n  account_id: 012345678901\n"))
+	assert.Nil(t, e)
+	assert.Equal(t, "012345678901", *c.Global.AccountID)
+}
+
+func TestConfigVersion(t *testing.T) {
+	c, e := ReadConfig(strings.NewReader(`{"version": 3, "defaults": {"account_id": "000000000001"}}`))
+	assert.Nil(t, e)
+	assert.Equal(t, 3, c.Version)
+	assert.Equal(t, "000000000001", *c.Defaults.AccountID)
+
+	// numeric account ids are read at every version
+	c, e = ReadConfig(strings.NewReader(`{"version": 3, "defaults": {"account_id": 1}, "global": {"account_id": 12345678901}}`))
+	assert.Nil(t, e)
+	assert.Equal(t, "000000000001", *c.Defaults.AccountID)
+	assert.Equal(t, "012345678901", *c.Global.AccountID)
+
+	_, e = ReadConfig(strings.NewReader("{\n  \"version\": 99\n}"))
+	assert.Equal(t, `2:3: version 99 is newer than this fogg supports (3), please upgrade fogg`, e.Error())
+
+	_, e = ReadConfig(strings.NewReader(`{"version": "two"}`))
+	assert.Equal(t, `1:2: version "two" is not a valid config version`, e.Error())
+}
+
+func TestUpgrade(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	json := `{
+  "defaults": {"account_id": 1, "owner": "foo"},
+  "accounts": {"foo": {"account_id": 12345678901}}
+}`
+	yml := `# our config
+defaults:
+  account_id: 1 # the main account
+  owner: foo
+`
+	assert.Nil(t, afero.WriteFile(fs, "fogg.json", []byte(json), 0644))
+	assert.Nil(t, afero.WriteFile(fs, "fogg.yml", []byte(yml), 0644))
+
+	migrations, e := Upgrade(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.Equal(t, []Migration{{
+		From:        1,
+		To:          2,
+		Description: "store account ids as 12 digit strings",
+		Paths:       []string{"defaults.account_id", "accounts.foo.account_id"},
+	}, {
+		From:        2,
+		To:          3,
+		Description: "keep remote state for every sibling component",
+		Paths:       []string{"defaults.remote_state_siblings"},
+	}}, migrations)
+	b, e := afero.ReadFile(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.Equal(t, `{
+  "version": 3,
+  "defaults": {
+    "account_id": "000000000001",
+    "owner": "foo",
+    "remote_state_siblings": true
+  },
+  "accounts": {
+    "foo": {
+      "account_id": "012345678901"
+    }
+  }
+}
+`, string(b))
+
+	// already current, nothing to do
+	migrations, e = Upgrade(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.Empty(t, migrations)
+
+	migrations, e = Upgrade(fs, "fogg.yml")
+	assert.Nil(t, e)
+	assert.Len(t, migrations, 2)
+	b, e = afero.ReadFile(fs, "fogg.yml")
+	assert.Nil(t, e)
+	assert.Equal(t, `# our config
+version: 3
+defaults:
+  account_id: "000000000001" # the main account
+  owner: foo
+  remote_state_siblings: true
+`, string(b))
+
+	c, e := FindAndReadConfig(fs, "fogg.yml")
+	assert.Nil(t, e)
+	assert.Equal(t, CurrentVersion, c.Version)
+}
+
+func TestUpgradeKeepsHTMLCharacters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	json := `{
+  "defaults": {"account_id": 1},
+  "envs": {"staging": {"components": {"db": {"module_source": "git::https://example.com/db.git?ref=a&b", "owner": "<db@example.com>"}}}}
+}`
+	assert.Nil(t, afero.WriteFile(fs, "fogg.json", []byte(json), 0644))
+
+	_, e := Upgrade(fs, "fogg.json")
+	assert.Nil(t, e)
+	b, e := afero.ReadFile(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.Contains(t, string(b), `"module_source": "git::https://example.com/db.git?ref=a&b"`)
+	assert.Contains(t, string(b), `"owner": "<db@example.com>"`)
+
+	c, e := FindAndReadConfig(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.Equal(t, "git::https://example.com/db.git?ref=a&b", *c.Envs["staging"].Components["db"].ModuleSource)
+}
+
+func TestIncludes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"fogg.json": `{
+  "defaults": {"owner": "foo", "extra_vars": {"a": "1"}},
+  "envs": {"prod": {}}
+}`,
+		"fogg.d/staging.yml": `envs:
+  staging:
+    components:
+      db: {}
+`,
+		"fogg.d/accounts.json": `{
+  "defaults": {"project": "bar", "extra_vars": {"b": "2"}},
+  "accounts": {"main": {"account_id": "000000000001"}}
+}`,
+		"fogg.d/README.md": "ignored",
+	}
+	for name, contents := range files {
+		assert.Nil(t, afero.WriteFile(fs, name, []byte(contents), 0644))
+	}
+
+	c, e := FindAndReadConfig(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.Equal(t, "foo", c.Defaults.Owner)
+	assert.Equal(t, "bar", c.Defaults.Project)
+	assert.Equal(t, map[string]ExtraVar{"a": {Default: "1"}, "b": {Default: "2"}}, c.Defaults.ExtraVars)
+	assert.Contains(t, c.Envs, "prod")
+	assert.Contains(t, c.Envs["staging"].Components, "db")
+	assert.Equal(t, "000000000001", *c.Accounts["main"].AccountID)
+
+	assert.Equal(t, "fogg.json", c.Source("envs.prod"))
+	assert.Equal(t, "fogg.d/staging.yml", c.Source("envs.staging"))
+	assert.Equal(t, "fogg.d/staging.yml", c.Source("envs.staging.components.db"))
+	assert.Equal(t, "fogg.d/accounts.json", c.Source("accounts.main"))
+
+	// the same env in two files
+	assert.Nil(t, afero.WriteFile(fs, "fogg.d/prod.yml", []byte("envs:\n  prod:\n    owner: baz\n  staging: {}\n"), 0644))
+	assert.Nil(t, afero.WriteFile(fs, "fogg.d/zzz.json", []byte(`{"defaults": {"owner": "baz"}}`), 0644))
+	_, e = FindAndReadConfig(fs, "fogg.json")
+	assert.NotNil(t, e)
+	merr, ok := e.(*multierror.Error)
+	assert.True(t, ok)
+	var msgs []string
+	for _, err := range merr.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		`fogg.d/prod.yml:2:3: envs.prod is already defined at fogg.json:3:12`,
+		`fogg.d/staging.yml:2:3: envs.staging is already defined at fogg.d/prod.yml:4:3`,
+		`fogg.d/zzz.json:1:15: defaults.owner is already defined at fogg.json:2:16`,
+	}, msgs)
+}
+
+func TestSchema(t *testing.T) {
+	b, e := Schema()
+	assert.Nil(t, e)
+
+	var s map[string]interface{}
+	assert.Nil(t, jsonlib.Unmarshal(b, &s))
+	assert.Equal(t, "http://json-schema.org/draft-07/schema#", s["$schema"])
+
+	properties := s["properties"].(map[string]interface{})
+	assert.Len(t, properties, len(jsonFields(reflect.TypeOf(Config{}))))
+
+	defaults := properties["defaults"].(map[string]interface{})
+	assert.Equal(t, false, defaults["additionalProperties"])
+	assert.Equal(t, []interface{}{
+		"aws_profile_backend",
+		"aws_profile_provider",
+		"aws_provider_version",
+		"aws_region_backend",
+		"aws_region_provider",
+		"infra_s3_bucket",
+		"owner",
+		"project",
+		"terraform_version",
+	}, defaults["required"])
+
+	accountID := defaults["properties"].(map[string]interface{})["account_id"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": "string", "pattern": "^[0-9]{12}$"},
+		map[string]interface{}{"type": "integer", "minimum": 0.0, "maximum": 999999999999.0},
+	}, accountID["oneOf"])
+
+	plugins := properties["plugins"].(map[string]interface{})["properties"].(map[string]interface{})
+	plugin := plugins["custom_plugins"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"format", "url"}, plugin["required"])
+	format := plugin["properties"].(map[string]interface{})["format"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"tar"}, format["enum"])
+
+	component := properties["envs"].(map[string]interface{})["additionalProperties"].(map[string]interface{})["properties"].(map[string]interface{})["components"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	assert.Contains(t, component["properties"], "module_source")
+	// extending envs drop components by setting them to null
+	assert.Equal(t, []interface{}{"object", "null"}, component["type"])
+	accountComponent := properties["accounts"].(map[string]interface{})["additionalProperties"].(map[string]interface{})["properties"].(map[string]interface{})["components"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	assert.Equal(t, "object", accountComponent["type"])
+}
+
+// errorStrings sorts errors by position and returns their messages.
+func errorStrings(errs []error) []string {
+	sortErrors(errs)
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
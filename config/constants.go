@@ -1,6 +1,7 @@
 package config
 
 var reservedVariableNames = map[string]bool{
+	"account":      true,
 	"aws_accounts": true,
 	"aws_profile":  true,
 	"env":          true,
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Configs are read into a yaml.Node tree whatever their format. Nodes keep
+// key order, comments and positions, which lets us point errors at the right
+// line and rewrite configs during upgrades without reformatting them.
+
+// parseDocument parses a config into a document node.
+func parseDocument(b []byte, format Format) (*yaml.Node, error) {
+	if format == FormatJSON {
+		root, e := parseJSON(b)
+		if e != nil {
+			return nil, e
+		}
+		return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}, nil
+	}
+	doc := &yaml.Node{}
+	e := yaml.Unmarshal(b, doc)
+	if e != nil {
+		return nil, e
+	}
+	if doc.Kind == 0 {
+		// an empty file
+		doc = &yaml.Node{Kind: yaml.DocumentNode}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	return doc, nil
+}
+
+// parseJSON builds a node tree from JSON. We can't hand JSON to the YAML
+// parser since JSON allows escapes that YAML doesn't.
+func parseJSON(b []byte) (*yaml.Node, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	// start returns the offset of the next token, skipping separators
+	start := func() int64 {
+		o := d.InputOffset()
+		for o < int64(len(b)) && strings.IndexByte(" \t\r\n,:", b[o]) >= 0 {
+			o++
+		}
+		return o
+	}
+
+	var value func() (*yaml.Node, error)
+	value = func() (*yaml.Node, error) {
+		pos := offsetPos(b, start(), "")
+		tok, e := d.Token()
+		if e != nil {
+			return nil, e
+		}
+		n := &yaml.Node{Line: pos.Line, Column: pos.Column}
+		switch t := tok.(type) {
+		case json.Delim:
+			if t == '{' {
+				n.Kind, n.Tag = yaml.MappingNode, "!!map"
+			} else {
+				n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+			}
+			for d.More() {
+				if n.Kind == yaml.MappingNode {
+					keyPos := offsetPos(b, start(), "")
+					key, e := d.Token()
+					if e != nil {
+						return nil, e
+					}
+					n.Content = append(n.Content, &yaml.Node{
+						Kind:   yaml.ScalarNode,
+						Tag:    "!!str",
+						Value:  key.(string),
+						Line:   keyPos.Line,
+						Column: keyPos.Column,
+					})
+				}
+				item, e := value()
+				if e != nil {
+					return nil, e
+				}
+				n.Content = append(n.Content, item)
+			}
+			_, e = d.Token() // closing delimiter
+			if e != nil {
+				return nil, e
+			}
+		case string:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!str", t
+		case json.Number:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!int", t.String()
+			if strings.ContainsAny(t.String(), ".eE") {
+				n.Tag = "!!float"
+			}
+		case bool:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!bool", "false"
+			if t {
+				n.Value = "true"
+			}
+		case nil:
+			n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!null", "null"
+		}
+		return n, nil
+	}
+
+	root, e := value()
+	if e != nil {
+		return nil, e
+	}
+	if _, e := d.Token(); e != io.EOF {
+		if e != nil {
+			return nil, e
+		}
+		return nil, errors.New("unexpected data after the top-level value")
+	}
+	return root, nil
+}
+
+// documentRoot returns the top-level value of a document.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// mappingPairs returns the key/value pairs of a mapping, resolving aliases
+// and YAML merge keys (<<: *anchor). Keys set directly win over merged ones.
+func mappingPairs(n *yaml.Node) [][2]*yaml.Node {
+	n = resolveAlias(n)
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	var merged, pairs [][2]*yaml.Node
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if key.ShortTag() == "!!merge" {
+			val = resolveAlias(val)
+			sources := []*yaml.Node{val}
+			if val.Kind == yaml.SequenceNode {
+				sources = val.Content
+			}
+			for _, source := range sources {
+				merged = append(merged, mappingPairs(source)...)
+			}
+			continue
+		}
+		seen[key.Value] = true
+		pairs = append(pairs, [2]*yaml.Node{key, val})
+	}
+	for _, pair := range merged {
+		if !seen[pair[0].Value] {
+			seen[pair[0].Value] = true
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n != nil && n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// mappingValue returns the value of key in a mapping, or nil.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for _, pair := range mappingPairs(n) {
+		if pair[0].Value == key {
+			return pair[1]
+		}
+	}
+	return nil
+}
+
+// documentJSON converts a document to JSON so that it can be decoded using the
+// json struct tags on Config. Keys keep their order.
+func documentJSON(n *yaml.Node) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	e := writeJSON(buf, n)
+	return buf.Bytes(), e
+}
+
+func writeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	n = resolveAlias(n)
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSON(buf, n.Content[0])
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i, pair := range mappingPairs(n) {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, e := marshalJSON(pair[0].Value)
+			if e != nil {
+				return e
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			e = writeJSON(buf, pair[1])
+			if e != nil {
+				return e
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			e := writeJSON(buf, item)
+			if e != nil {
+				return e
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		var v interface{} = n.Value
+		switch n.ShortTag() {
+		case "!!null":
+			v = nil
+		case "!!bool", "!!int", "!!float":
+			e := n.Decode(&v)
+			if e != nil {
+				return errors.Wrapf(e, "line %d", n.Line)
+			}
+		}
+		b, e := marshalJSON(v)
+		if e != nil {
+			return errors.Wrapf(e, "line %d", n.Line)
+		}
+		buf.Write(b)
+	default:
+		return errors.Errorf("unsupported yaml on line %d", n.Line)
+	}
+	return nil
+}
+
+// marshalJSON is json.Marshal without escaping &, < and >, which show up in
+// module sources like ?ref=a&b.
+func marshalJSON(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	e := enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), e
+}
+
+// encodeDocument writes a document back out in the given format.
+func encodeDocument(doc *yaml.Node, format Format) ([]byte, error) {
+	if format == FormatJSON {
+		compact, e := documentJSON(doc)
+		if e != nil {
+			return nil, e
+		}
+		buf := &bytes.Buffer{}
+		e = json.Indent(buf, compact, "", "  ")
+		if e != nil {
+			return nil, e
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	e := enc.Encode(doc)
+	if e != nil {
+		return nil, errors.Wrap(e, "unable to marshal yaml")
+	}
+	return buf.Bytes(), enc.Close()
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ExtraVar is a terraform variable fogg defines in every directory of a scope.
+// It's written either as its default, which can be any JSON value, or as an
+// object with a default and a description:
+//
+//	"extra_vars": {
+//	  "cidrs": ["10.0.0.0/16"],
+//	  "size": {"default": 3, "description": "number of instances"}
+//	}
+//
+// A map with a default key is read as the object form, and its other keys
+// have to be description, wrap it in another default to use it as a value.
+type ExtraVar struct {
+	// Default is a string, json.Number, bool, []interface{} or
+	// map[string]interface{}, nil if the variable has no default
+	Default     interface{}
+	Description string
+}
+
+// HasDefault is false for variables terraform will ask for.
+func (v ExtraVar) HasDefault() bool {
+	return v.Default != nil
+}
+
+func (v *ExtraVar) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var value interface{}
+	e := d.Decode(&value)
+	if e != nil {
+		return e
+	}
+	*v = ExtraVar{Default: value}
+	if obj, ok := value.(map[string]interface{}); ok && isExtraVarObject(obj) {
+		v.Default = obj["default"]
+		v.Description, _ = obj["description"].(string)
+	}
+	return nil
+}
+
+func (v ExtraVar) MarshalJSON() ([]byte, error) {
+	obj, isMap := v.Default.(map[string]interface{})
+	if v.Description == "" && !(isMap && isExtraVarObject(obj)) {
+		return json.Marshal(v.Default)
+	}
+	object := map[string]interface{}{"default": v.Default}
+	if v.Description != "" {
+		object["description"] = v.Description
+	}
+	return json.Marshal(object)
+}
+
+// isExtraVarObject decides whether obj is the object form of an extra var.
+func isExtraVarObject(obj map[string]interface{}) bool {
+	_, ok := obj["default"]
+	return ok
+}
+
+// extraVarObject is the object form of an ExtraVar, so that its keys are
+// checked like any other.
+type extraVarObject struct {
+	Default     interface{} `json:"default"`
+	Description string      `json:"description"`
+}
+
+// nullPaths finds the nulls in a value, which terraform literals can't
+// express.
+func nullPaths(value interface{}, path string) []string {
+	var paths []string
+	switch v := value.(type) {
+	case nil:
+		paths = append(paths, path)
+	case []interface{}:
+		for i, item := range v {
+			paths = append(paths, nullPaths(item, fmt.Sprintf("%s.%d", path, i))...)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			paths = append(paths, nullPaths(v[key], path+"."+key)...)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the serialization format of a fogg config file
+type Format string
+
+const (
+	// FormatJSON is used for fogg.json
+	FormatJSON Format = "json"
+	// FormatYAML is used for fogg.yml and fogg.yaml
+	FormatYAML Format = "yaml"
+)
+
+// FormatFromPath determines the config format from a file's extension.
+func FormatFromPath(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, true
+	case ".yml", ".yaml":
+		return FormatYAML, true
+	}
+	return "", false
+}
+
+// sniffFormat guesses the format of a config document from its contents. A
+// JSON config is always an object, so anything else is treated as YAML.
+func sniffFormat(b []byte) Format {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return FormatJSON
+	}
+	return FormatYAML
+}
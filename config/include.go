@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// IncludeDir is the directory, next to the main config file, whose .json and
+// .yml files are merged into the config. Teams usually keep one file per env
+// or account there to avoid conflicts in a single large fogg.json.
+const IncludeDir = "fogg.d"
+
+// ConfigFiles lists configFile followed by the files it includes, in the
+// order they are merged.
+func ConfigFiles(fs afero.Fs, configFile string) ([]string, error) {
+	dir := filepath.Join(filepath.Dir(configFile), IncludeDir)
+	infos, e := afero.ReadDir(fs, dir)
+	if os.IsNotExist(e) {
+		return []string{configFile}, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	var includes []string
+	for _, info := range infos {
+		if _, ok := FormatFromPath(info.Name()); ok && !info.IsDir() {
+			includes = append(includes, filepath.Join(dir, info.Name()))
+		}
+	}
+	sort.Strings(includes)
+	return append([]string{configFile}, includes...), nil
+}
+
+// isInclude tells if path is one of the files in an IncludeDir.
+func isInclude(path string) bool {
+	return filepath.Base(filepath.Dir(path)) == IncludeDir
+}
+
+// isEntityPath tells if path names an account, env or component. These have
+// to be defined in a single file, everything else is deep-merged.
+func isEntityPath(path string) bool {
+	parts := strings.Split(path, ".")
+	switch len(parts) {
+	case 2:
+		return parts[0] == "accounts" || parts[0] == "envs"
+	case 4:
+		return (parts[0] == "accounts" || parts[0] == "envs") && parts[2] == "components"
+	}
+	return false
+}
+
+// mergeDocuments deep-merges src into dst. Keys set in both are an error,
+// naming both files, unless both values are objects that aren't accounts,
+// envs or components.
+func mergeDocuments(dst, src *yaml.Node, dstPositions, srcPositions *positions) []error {
+	var errs []error
+	var merge func(dst, src *yaml.Node, path string)
+	merge = func(dst, src *yaml.Node, path string) {
+		for _, pair := range mappingPairs(src) {
+			key := pair[0].Value
+			keyPath := joinPath(path, key)
+			existing := mappingValue(dst, key)
+			switch {
+			case existing == nil:
+				dst.Content = append(dst.Content, pair[0], pair[1])
+			case keyPath == "version":
+				// every file is migrated to the current version before merging
+			case isEntityPath(keyPath) || resolveAlias(existing).Kind != yaml.MappingNode || resolveAlias(pair[1]).Kind != yaml.MappingNode:
+				errs = append(errs, Error{
+					Pos:  srcPositions.lookup(keyPath),
+					Path: keyPath,
+					Msg:  fmt.Sprintf("is already defined at %s", dstPositions.lookup(keyPath)),
+				})
+			default:
+				merge(resolveAlias(existing), pair[1], keyPath)
+			}
+		}
+	}
+	merge(documentRoot(dst), documentRoot(src), "")
+
+	for path, pos := range srcPositions.keys {
+		if _, ok := dstPositions.keys[path]; !ok {
+			dstPositions.keys[path] = pos
+		}
+	}
+	return errs
+}
+
+// Source returns the config file that defines the key at path, such as
+// envs.staging or accounts.prod.
+func (c *Config) Source(path string) string {
+	return c.positions.lookup(path).Filename
+}
+
+// Position returns where the key at path is defined, or its closest parent
+// that is.
+func (c *Config) Position(path string) Pos {
+	return c.positions.lookup(path)
+}
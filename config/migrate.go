@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the newest config version this fogg understands. Configs
+// without a version key are version 1.
+const CurrentVersion = 3
+
+type migration struct {
+	description string
+	// mainOnly migrations change repo wide settings, they would be merged
+	// into whatever the main config file says if they ran on includes
+	mainOnly bool
+	// migrate rewrites the document in place, returning the paths it changed
+	migrate func(root *yaml.Node) []string
+}
+
+// migrations[i] upgrades a config from version i+1 to version i+2. New
+// migrations go at the end, along with a bump of CurrentVersion.
+var migrations = []migration{
+	{"store account ids as 12 digit strings", false, stringifyAccountIDs},
+	{"keep remote state for every sibling component", true, keepRemoteStateSiblings},
+}
+
+// keepRemoteStateSiblings sets remote_state_siblings in defaults. Components
+// used to get remote state for all of their siblings, now they only get it
+// for the ones in depends_on unless it's set.
+func keepRemoteStateSiblings(root *yaml.Node) []string {
+	defaults := mappingValue(root, "defaults")
+	if defaults == nil || defaults.Kind != yaml.MappingNode || mappingValue(defaults, "remote_state_siblings") != nil {
+		return nil
+	}
+	defaults.Content = append(defaults.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "remote_state_siblings"},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"},
+	)
+	return []string{"defaults.remote_state_siblings"}
+}
+
+// Migration describes a single upgrade step applied to a config.
+type Migration struct {
+	From        int
+	To          int
+	Description string
+	// Paths are the keys that were changed
+	Paths []string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("version %d -> %d: %s (%d change(s))", m.From, m.To, m.Description, len(m.Paths))
+}
+
+// configVersion returns the version of a document along with the node holding
+// it, which is nil when the version isn't set.
+func configVersion(root *yaml.Node, p *positions) (int, *yaml.Node, error) {
+	n := mappingValue(root, "version")
+	if n == nil {
+		return 1, nil, nil
+	}
+	v, e := strconv.Atoi(n.Value)
+	if e != nil || n.Kind != yaml.ScalarNode || v < 1 {
+		return 0, nil, Error{Pos: p.lookup("version"), Path: "version", Msg: fmt.Sprintf("%q is not a valid config version", n.Value)}
+	}
+	if v > CurrentVersion {
+		return 0, nil, Error{
+			Pos:  p.lookup("version"),
+			Path: "version",
+			Msg:  fmt.Sprintf("%d is newer than this fogg supports (%d), please upgrade fogg", v, CurrentVersion),
+		}
+	}
+	return v, n, nil
+}
+
+// migrate brings a document up to CurrentVersion, returning the steps taken.
+// Includes skip the mainOnly migrations.
+func migrate(doc *yaml.Node, p *positions, include bool) ([]Migration, error) {
+	root := documentRoot(doc)
+	version, versionNode, e := configVersion(root, p)
+	if e != nil {
+		return nil, e
+	}
+
+	var applied []Migration
+	for v := version; v < CurrentVersion; v++ {
+		m := migrations[v-1]
+		var paths []string
+		if !include || !m.mainOnly {
+			paths = m.migrate(root)
+		}
+		applied = append(applied, Migration{
+			From:        v,
+			To:          v + 1,
+			Description: m.description,
+			Paths:       paths,
+		})
+	}
+	if len(applied) == 0 {
+		return nil, nil
+	}
+
+	if versionNode == nil {
+		versionNode = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int"}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+		if len(root.Content) > 0 {
+			// keep a comment at the top of the file at the top
+			key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+		root.Content = append([]*yaml.Node{key, versionNode}, root.Content...)
+	}
+	versionNode.Value = strconv.Itoa(CurrentVersion)
+	return applied, nil
+}
+
+// Upgrade rewrites the config at configFile to CurrentVersion, keeping its
+// format. It returns the migrations that were applied; the file isn't touched
+// when there are none.
+func Upgrade(fs afero.Fs, configFile string) ([]Migration, error) {
+	b, format, e := readFile(fs, configFile)
+	if e != nil {
+		return nil, e
+	}
+
+	doc, e := parseDocument(b, format)
+	if e != nil {
+		return nil, errors.Wrapf(parseError(e, b, format, &positions{filename: configFile}), "unable to parse %s config file", format)
+	}
+	applied, e := migrate(doc, nodePositions(doc, configFile), isInclude(configFile))
+	if e != nil || len(applied) == 0 {
+		return nil, e
+	}
+
+	out, e := encodeDocument(doc, format)
+	if e != nil {
+		return nil, errors.Wrap(e, "unable to encode config")
+	}
+	info, e := fs.Stat(configFile)
+	if e != nil {
+		return nil, errors.Wrap(e, "unable to stat config file")
+	}
+	e = afero.WriteFile(fs, configFile, out, info.Mode())
+	return applied, errors.Wrap(e, "unable to write config file")
+}
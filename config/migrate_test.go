@@ -0,0 +1,54 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateRemoteStateSiblings(t *testing.T) {
+	for _, json := range []string{
+		`{"defaults": {"owner": "foo"}}`,
+		`{"version": 2, "defaults": {"owner": "foo"}}`,
+	} {
+		c, e := ReadConfig(strings.NewReader(json))
+		assert.Nil(t, e)
+		assert.Equal(t, CurrentVersion, c.Version)
+		assert.True(t, c.Defaults.RemoteStateSiblings, json)
+	}
+
+	// explicit settings and current configs are left alone
+	c, e := ReadConfig(strings.NewReader(`{"version": 2, "defaults": {"remote_state_siblings": false}}`))
+	assert.Nil(t, e)
+	assert.False(t, c.Defaults.RemoteStateSiblings)
+	c, e = ReadConfig(strings.NewReader(`{"version": 3, "defaults": {"owner": "foo"}}`))
+	assert.Nil(t, e)
+	assert.False(t, c.Defaults.RemoteStateSiblings)
+}
+
+func TestMigrateRemoteStateSiblingsIncludes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, afero.WriteFile(fs, "fogg.json", []byte(`{"defaults": {"owner": "foo"}}`), 0644))
+	assert.Nil(t, afero.WriteFile(fs, "fogg.d/more.json", []byte(`{"defaults": {"project": "bar"}}`), 0644))
+	c, e := FindAndReadConfig(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.True(t, c.Defaults.RemoteStateSiblings)
+	assert.Equal(t, "fogg.json", c.Source("defaults.remote_state_siblings"))
+
+	// an unversioned include doesn't turn it on for a current config
+	assert.Nil(t, afero.WriteFile(fs, "fogg.json", []byte(`{"version": 3, "defaults": {"owner": "foo"}}`), 0644))
+	c, e = FindAndReadConfig(fs, "fogg.json")
+	assert.Nil(t, e)
+	assert.False(t, c.Defaults.RemoteStateSiblings)
+	assert.Equal(t, "bar", c.Defaults.Project)
+
+	migrations, e := Upgrade(fs, "fogg.d/more.json")
+	assert.Nil(t, e)
+	assert.Len(t, migrations, 2)
+	assert.Empty(t, migrations[1].Paths)
+	b, e := afero.ReadFile(fs, "fogg.d/more.json")
+	assert.Nil(t, e)
+	assert.NotContains(t, string(b), "remote_state_siblings")
+}
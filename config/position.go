@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/go-multierror"
+	validator "gopkg.in/go-playground/validator.v9"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Pos is a location in a config file. Line and Column start at 1, a zero Line
+// means we only know the file.
+type Pos struct {
+	Filename string
+	Line     int
+	Column   int
+}
+
+func (p Pos) String() string {
+	var s []string
+	if p.Filename != "" {
+		s = append(s, p.Filename)
+	}
+	if p.Line > 0 {
+		s = append(s, strconv.Itoa(p.Line))
+		if p.Column > 0 {
+			s = append(s, strconv.Itoa(p.Column))
+		}
+	}
+	if len(s) == 0 {
+		return "<config>"
+	}
+	return strings.Join(s, ":")
+}
+
+// before orders positions by file, then line and column.
+func (p Pos) before(o Pos) bool {
+	if p.Filename != o.Filename {
+		return p.Filename < o.Filename
+	}
+	if p.Line != o.Line {
+		return p.Line < o.Line
+	}
+	return p.Column < o.Column
+}
+
+// Error is a problem with a config, tied to where it is in the config file so
+// that editors can jump to it.
+type Error struct {
+	Pos Pos
+	// Path is the dotted path to the offending key, empty if the problem
+	// isn't about a specific key
+	Path string
+	Msg  string
+}
+
+func (e Error) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s %s", e.Pos, e.Path, e.Msg)
+}
+
+// positions maps dotted key paths (envs.staging.components.db) to where the
+// key is defined in the config file.
+type positions struct {
+	filename string
+	keys     map[string]Pos
+}
+
+// lookup finds the position of path, falling back to its closest parent
+// that's in the file. Required keys are usually missing, in which case the
+// best we can do is point at the object that should contain them.
+func (p *positions) lookup(path string) Pos {
+	if p == nil {
+		return Pos{}
+	}
+	for path != "" {
+		if pos, ok := p.keys[path]; ok {
+			return pos
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return Pos{Filename: p.filename}
+}
+
+// Locate ties validation errors to their position in the config file. Errors
+// that aren't about a specific key are returned unchanged.
+func (c *Config) Locate(err error) error {
+	switch e := err.(type) {
+	case validator.ValidationErrors:
+		var errs []error
+		for _, fe := range e {
+			path := namespacePath(fe.Namespace())
+			errs = append(errs, Error{
+				Pos:  c.positions.lookup(path),
+				Path: path,
+				Msg:  validationMessage(fe),
+			})
+		}
+		return multierror.Append(nil, errs...)
+	case *multierror.Error:
+		if e == nil {
+			return nil
+		}
+		located := &multierror.Error{}
+		for _, err := range e.Errors {
+			located = multierror.Append(located, c.Locate(err))
+		}
+		return located
+	}
+	return err
+}
+
+var namespaceKey = regexp.MustCompile(`\[([^\]]*)\]`)
+
+// namespacePath turns a validator namespace like Config.envs[staging].owner
+// into a dotted key path.
+func namespacePath(namespace string) string {
+	path := namespaceKey.ReplaceAllString(namespace, ".$1")
+	if i := strings.Index(path, "."); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func validationMessage(fe validator.FieldError) string {
+	if fe.Tag() == "required" {
+		return "is required"
+	}
+	return fmt.Sprintf("failed the %s check", fe.Tag())
+}
+
+// parseError turns an error from decoding the config into an Error pointing at
+// the problem.
+func parseError(e error, b []byte, format Format, p *positions) error {
+	switch t := e.(type) {
+	case *json.SyntaxError:
+		// Offset is just past the offending character
+		return Error{Pos: offsetPos(b, t.Offset-1, p.filename), Msg: t.Error()}
+	case *json.UnmarshalTypeError:
+		msg := fmt.Sprintf("cannot be a %s, expected %s", t.Value, t.Type)
+		return Error{Pos: p.lookup(t.Field), Path: t.Field, Msg: msg}
+	}
+	if format == FormatYAML {
+		if m := yamlErrorLine.FindStringSubmatch(e.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return Error{Pos: Pos{Filename: p.filename, Line: line}, Msg: m[2]}
+		}
+	}
+	return Error{Pos: Pos{Filename: p.filename}, Msg: e.Error()}
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// offsetPos converts a byte offset into a line and column.
+func offsetPos(b []byte, offset int64, filename string) Pos {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := b[:offset]
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return Pos{
+		Filename: filename,
+		Line:     bytes.Count(before, []byte("\n")) + 1,
+		Column:   utf8.RuneCount(before[lineStart:]) + 1,
+	}
+}
+
+// nodePositions records where every key of a document is defined.
+func nodePositions(doc *yaml.Node, filename string) *positions {
+	p := &positions{filename: filename, keys: map[string]Pos{}}
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		n = resolveAlias(n)
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for _, pair := range mappingPairs(n) {
+				keyPath := joinPath(path, pair[0].Value)
+				p.keys[keyPath] = Pos{Filename: filename, Line: pair[0].Line, Column: pair[0].Column}
+				walk(pair[1], keyPath)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				itemPath := joinPath(path, strconv.Itoa(i))
+				p.keys[itemPath] = Pos{Filename: filename, Line: c.Line, Column: c.Column}
+				walk(c, itemPath)
+			}
+		}
+	}
+	walk(doc, "")
+	return p
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/chanzuckerberg/fogg/plugins"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// enums lists the allowed values of string types that are enumerations.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(plugins.TypePluginFormat("")): pluginFormats(),
+}
+
+// fieldSchemas overrides the generated schema for fields that accept more
+// than their Go type suggests.
+var fieldSchemas = map[string]map[string]interface{}{
+	// numbers are read as account ids too, padded to 12 digits
+	"account_id": {
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string", "pattern": validAccountID.String()},
+			map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 999999999999},
+		},
+	},
+	"aws_region_backend":  regionSchema,
+	"aws_region_provider": regionSchema,
+	"aws_regions": {
+		"type":  "array",
+		"items": regionSchema,
+	},
+	"extra_vars": {
+		"type": "object",
+		"additionalProperties": map[string]interface{}{
+			"description": "the variable's default, or an object with default and description",
+		},
+	},
+	"version": {
+		"type":    "integer",
+		"minimum": 1,
+		"maximum": CurrentVersion,
+	},
+}
+
+// nullableValues names the map field of a struct whose values can be null.
+// An env sets a component to null to drop one from the env it extends.
+var nullableValues = map[reflect.Type]string{
+	reflect.TypeOf(Env{}): "components",
+}
+
+var regionSchema = map[string]interface{}{
+	"type": "string",
+	"enum": allRegions,
+}
+
+func pluginFormats() []string {
+	formats := make([]string, len(plugins.TypePluginFormats))
+	for i, f := range plugins.TypePluginFormats {
+		formats[i] = string(f)
+	}
+	return formats
+}
+
+// Schema generates a JSON Schema (draft-07) for fogg configs from the Config
+// struct, so editors can validate and autocomplete fogg.json and fogg.yml.
+func Schema() ([]byte, error) {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["$schema"] = schemaDraft
+	s["title"] = "fogg config"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if values, ok := enums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for name, field := range jsonFields(t) {
+			if override, ok := fieldSchemas[name]; ok {
+				properties[name] = override
+			} else {
+				properties[name] = typeSchema(field.Type)
+			}
+			if nullableValues[t] == name {
+				values := properties[name].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+				values["type"] = []string{"object", "null"}
+			}
+			if hasValidation(field, "required") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	// anything else (interface{}) can be any value
+	return map[string]interface{}{}
+}
+
+func hasValidation(f reflect.StructField, tag string) bool {
+	for _, v := range strings.Split(f.Tag.Get("validate"), ",") {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
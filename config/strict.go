@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/agext/levenshtein"
+)
+
+// UnknownField is a key in the config that fogg doesn't know about, usually a
+// typo of a real one.
+type UnknownField struct {
+	Pos Pos
+	// Path is the dotted path to the key, like defaults.aws_region
+	Path string
+	// Suggestion is the closest valid key, empty if nothing is close
+	Suggestion string
+}
+
+func (u UnknownField) Error() string {
+	if u.Suggestion == "" {
+		return fmt.Sprintf("%s: %s is not a valid key", u.Pos, u.Path)
+	}
+	return fmt.Sprintf("%s: %s is not a valid key, did you mean %s?", u.Pos, u.Path, u.Suggestion)
+}
+
+// UnknownFieldsError is returned by ReadConfig when the config contains keys
+// that don't map to any setting.
+type UnknownFieldsError []UnknownField
+
+func (e UnknownFieldsError) Error() string {
+	msgs := make([]string, len(e))
+	for i, u := range e {
+		msgs[i] = u.Error()
+	}
+	return fmt.Sprintf("config has %d unknown key(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// findUnknownFields compares a decoded JSON document against the Config
+// struct and reports every key that wouldn't be decoded into it.
+func findUnknownFields(doc interface{}) []UnknownField {
+	return unknownFields(doc, reflect.TypeOf(Config{}), nil)
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func unknownFields(v interface{}, t reflect.Type, path []string) []UnknownField {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(ExtraVar{}) {
+		if obj, ok := v.(map[string]interface{}); ok && isExtraVarObject(obj) {
+			return unknownFields(v, reflect.TypeOf(extraVarObject{}), path)
+		}
+		return nil
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		// types that decode themselves take any value
+		return nil
+	}
+
+	var unknown []UnknownField
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			// type mismatches are reported by json.Unmarshal
+			return nil
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			keyPath := append(path[:len(path):len(path)], key)
+			field, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, UnknownField{
+					Path:       strings.Join(keyPath, "."),
+					Suggestion: suggest(key, fields),
+				})
+				continue
+			}
+			unknown = append(unknown, unknownFields(obj[key], field.Type, keyPath)...)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(obj) {
+			keyPath := append(path[:len(path):len(path)], key)
+			unknown = append(unknown, unknownFields(obj[key], t.Elem(), keyPath)...)
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range arr {
+			itemPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			unknown = append(unknown, unknownFields(item, t.Elem(), itemPath)...)
+		}
+	}
+	return unknown
+}
+
+// jsonFields maps the json names of a struct's exported fields to the fields.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// suggest returns the valid key closest to key. Keys that extend the unknown
+// one (aws_profile => aws_profile_backend) are always considered, anything
+// else has to be within a few edits.
+func suggest(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", -1
+	for _, candidate := range sortedFieldNames(fields) {
+		distance := levenshtein.Distance(key, candidate, nil)
+		related := strings.HasPrefix(candidate, key) || strings.HasPrefix(key, candidate)
+		if !related && distance > maxSuggestionDistance(key) {
+			continue
+		}
+		if bestDistance == -1 || distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func maxSuggestionDistance(key string) int {
+	if len(key) < 9 {
+		return 2
+	}
+	return len(key) / 3
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFieldNames(m map[string]reflect.StructField) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
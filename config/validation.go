@@ -0,0 +1,271 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	getter "github.com/hashicorp/go-getter"
+	"github.com/spf13/afero"
+)
+
+// validName matches names that work both as a directory and as a terraform
+// identifier, since envs and components are used as both.
+var validName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// errorf creates an Error for the key at path.
+func (c *Config) errorf(path, format string, args ...interface{}) Error {
+	return Error{
+		Pos:  c.positions.lookup(path),
+		Path: path,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// validateRegions makes sure every region we'll hand to terraform exists.
+func (c *Config) validateRegions() []error {
+	var errs []error
+	check := func(path string, region *string) {
+		if region == nil || *region == "" {
+			return
+		}
+		for _, r := range allRegions {
+			if r == *region {
+				return
+			}
+		}
+		errs = append(errs, c.errorf(path, "%q is not a known aws region", *region))
+	}
+	checkScope := func(path string, backend, provider *string, regions []string) {
+		check(path+".aws_region_backend", backend)
+		check(path+".aws_region_provider", provider)
+		for i := range regions {
+			check(fmt.Sprintf("%s.aws_regions.%d", path, i), &regions[i])
+		}
+	}
+
+	checkScope("defaults", &c.Defaults.AWSRegionBackend, &c.Defaults.AWSRegionProvider, c.Defaults.AWSRegions)
+	if c.Global != nil {
+		checkScope("global", c.Global.AWSRegionBackend, c.Global.AWSRegionProvider, c.Global.AWSRegions)
+	}
+	for name, account := range c.Accounts {
+		checkScope("accounts."+name, account.AWSRegionBackend, account.AWSRegionProvider, account.AWSRegions)
+	}
+	for envName, env := range c.Envs {
+		checkScope("envs."+envName, env.AWSRegionBackend, env.AWSRegionProvider, env.AWSRegions)
+	}
+	c.eachComponent(func(path string, component *Component) {
+		checkScope(path, component.AWSRegionBackend, component.AWSRegionProvider, component.AWSRegions)
+	})
+	return errs
+}
+
+// validateAccountIDs makes sure account ids look like aws account ids and
+// that no two accounts claim the same one.
+func (c *Config) validateAccountIDs() []error {
+	var errs []error
+	check := func(path string, id *string) {
+		if id != nil && !validAccountID.MatchString(*id) {
+			errs = append(errs, c.errorf(path, "%q is not a 12 digit aws account id", *id))
+		}
+	}
+	check("defaults.account_id", c.Defaults.AccountID)
+	if c.Global != nil {
+		check("global.account_id", c.Global.AccountID)
+	}
+	for envName, env := range c.Envs {
+		check("envs."+envName+".account_id", env.AccountID)
+	}
+	c.eachComponent(func(path string, component *Component) {
+		check(path+".account_id", component.AccountID)
+	})
+
+	byID := map[string][]string{}
+	for name, account := range c.Accounts {
+		check("accounts."+name+".account_id", account.AccountID)
+		if account.AccountID != nil {
+			byID[*account.AccountID] = append(byID[*account.AccountID], name)
+		}
+	}
+
+	for id, names := range byID {
+		if len(names) < 2 {
+			continue
+		}
+		// the first account in the file keeps the id
+		sort.Slice(names, func(i, j int) bool {
+			a, b := c.positions.lookup("accounts."+names[i]), c.positions.lookup("accounts."+names[j])
+			if a != b {
+				return a.before(b)
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names[1:] {
+			errs = append(errs, c.errorf("accounts."+name+".account_id", "%s is also used by account %s", id, names[0]))
+		}
+	}
+	return errs
+}
+
+// validateAccountReferences makes sure envs and components only name
+// accounts that exist.
+func (c *Config) validateAccountReferences() []error {
+	var errs []error
+	check := func(path string, name *string) {
+		if name == nil {
+			return
+		}
+		if _, ok := c.Accounts[*name]; !ok {
+			errs = append(errs, c.errorf(path, "%s is not a defined account", *name))
+		}
+	}
+	if c.Global != nil {
+		check("global.account", c.Global.Account)
+	}
+	for envName, env := range c.Envs {
+		check("envs."+envName+".account", env.Account)
+		for componentName, component := range env.Components {
+			if component != nil {
+				check(fmt.Sprintf("envs.%s.components.%s.account", envName, componentName), component.Account)
+			}
+		}
+	}
+	for accountName, account := range c.Accounts {
+		for componentName, component := range account.Components {
+			if component != nil && component.Account != nil {
+				path := fmt.Sprintf("accounts.%s.components.%s.account", accountName, componentName)
+				errs = append(errs, c.errorf(path, "can't be set, the component belongs to account %s", accountName))
+			}
+		}
+	}
+	return errs
+}
+
+// validateExtends makes sure envs extend envs that exist, without going in
+// circles.
+func (c *Config) validateExtends() []error {
+	var errs []error
+	for name, env := range c.Envs {
+		if env.Extends == nil {
+			continue
+		}
+		path := "envs." + name + ".extends"
+		if _, ok := c.Envs[*env.Extends]; !ok {
+			errs = append(errs, c.errorf(path, "%s is not a defined env", *env.Extends))
+			continue
+		}
+
+		chain := []string{name}
+		for current := env.Extends; current != nil; current = c.Envs[*current].Extends {
+			if _, ok := c.Envs[*current]; !ok {
+				// reported for the env that extends it
+				break
+			}
+			chain = append(chain, *current)
+			if *current == name {
+				errs = append(errs, c.errorf(path, "creates a cycle: %s", strings.Join(chain, " -> ")))
+				break
+			}
+			if len(chain) > len(c.Envs) {
+				// a cycle that doesn't include this env, reported for the envs in it
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// validateNames makes sure accounts, envs and components can be used as
+// directory names and terraform identifiers.
+func (c *Config) validateNames() []error {
+	var errs []error
+	check := func(path, name string) {
+		if !validName.MatchString(name) {
+			errs = append(errs, c.errorf(path, "is not a valid name, use letters, numbers, - and _ and start with a letter or _"))
+		}
+	}
+	for name, account := range c.Accounts {
+		check("accounts."+name, name)
+		for componentName := range account.Components {
+			check(fmt.Sprintf("accounts.%s.components.%s", name, componentName), componentName)
+		}
+	}
+	for envName, env := range c.Envs {
+		check("envs."+envName, envName)
+		for componentName := range env.Components {
+			check(fmt.Sprintf("envs.%s.components.%s", envName, componentName), componentName)
+		}
+	}
+	return errs
+}
+
+// validateModuleSources makes sure module_sources that are local paths exist.
+// Local paths are relative to the repo root.
+func (c *Config) validateModuleSources() []error {
+	fs := c.fs
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+
+	var errs []error
+	check := func(path string, component *Component) {
+		if component == nil || component.ModuleSource == nil || !isLocalModule(*component.ModuleSource) {
+			return
+		}
+		_, e := fs.Stat(*component.ModuleSource)
+		if e != nil {
+			errs = append(errs, c.errorf(path+".module_source", "%s does not exist", *component.ModuleSource))
+		}
+	}
+	check("global", c.Global)
+	c.eachComponent(check)
+	return errs
+}
+
+// eachComponent calls f with the path and config of every account and env
+// component.
+func (c *Config) eachComponent(f func(path string, component *Component)) {
+	for accountName, account := range c.Accounts {
+		for componentName, component := range account.Components {
+			if component != nil {
+				f(fmt.Sprintf("accounts.%s.components.%s", accountName, componentName), component)
+			}
+		}
+	}
+	for envName, env := range c.Envs {
+		for componentName, component := range env.Components {
+			if component != nil {
+				f(fmt.Sprintf("envs.%s.components.%s", envName, componentName), component)
+			}
+		}
+	}
+}
+
+// isLocalModule uses the same detection as module downloads to decide if a
+// module source is a path on disk.
+func isLocalModule(source string) bool {
+	s, e := getter.Detect(source, "/", getter.Detectors)
+	if e != nil {
+		return true
+	}
+	u, e := url.Parse(s)
+	return e != nil || u.Scheme == "file"
+}
+
+// sortErrors orders errors by where they are in the config file so they
+// read top to bottom.
+func sortErrors(errs []error) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, aok := errs[i].(Error)
+		b, bok := errs[j].(Error)
+		if !aok || !bok {
+			return aok && !bok
+		}
+		if a.Pos != b.Pos {
+			return a.Pos.before(b.Pos)
+		}
+		return a.Path < b.Path
+	})
+}
@@ -1,8 +1,6 @@
 package init
 
 import (
-	"encoding/json"
-
 	"github.com/chanzuckerberg/fogg/config"
 	"github.com/pkg/errors"
 	prompt "github.com/segmentio/go-prompt"
@@ -21,22 +19,29 @@ func userPrompt() (string, string, string, string, string) {
 	return project, region, bucket, profile, owner
 }
 
-func writeConfig(fs afero.Fs, config *config.Config) error {
-	json, e := json.MarshalIndent(config, "", "  ")
+func writeConfig(fs afero.Fs, config *config.Config, configFile string, format config.Format) error {
+	b, e := config.Marshal(format)
 	if e != nil {
-		return errors.Wrap(e, "unable to marshal json")
+		return errors.Wrapf(e, "unable to marshal %s", format)
 	}
-	configFile, e := fs.Create("fogg.json")
+	f, e := fs.Create(configFile)
 	if e != nil {
-		return errors.Wrap(e, "unable to create config file fogg.json")
+		return errors.Wrapf(e, "unable to create config file %s", configFile)
 	}
-	_, e3 := configFile.Write(json)
-	return e3
+	defer f.Close()
+	_, e = f.Write(b)
+	return e
 }
 
-func Init(fs afero.Fs) error {
+// Init prompts for the basic settings of a repo and writes them to configFile,
+// as YAML or JSON depending on its extension.
+func Init(fs afero.Fs, configFile string) error {
+	format, ok := config.FormatFromPath(configFile)
+	if !ok {
+		return errors.Errorf("unable to determine config format of %s, use .json, .yml or .yaml", configFile)
+	}
 	project, region, bucket, profile, owner := userPrompt()
 	config := config.InitConfig(project, region, bucket, profile, owner, AWSProviderVersion)
-	e := writeConfig(fs, config)
+	e := writeConfig(fs, config, configFile, format)
 	return e
 }
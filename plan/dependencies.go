@@ -0,0 +1,246 @@
+package plan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// RemoteState is a terraform_remote_state a component reads. The backend
+// settings are those of the component or account that owns the state, which
+// may differ from the reader's.
+type RemoteState struct {
+	// Name is the data source name, the plain component name for siblings
+	Name    string `json:"name"`
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key"`
+	Region  string `json:"region"`
+	Profile string `json:"profile"`
+}
+
+// A dependency is written in depends_on as one of
+//
+//	<component>                       a sibling in the same env or account
+//	envs/<env>/<component>            a component in any env
+//	accounts/<account>                an account
+//	accounts/<account>/<component>    a component in any account
+//
+// Everything but global reads global's state, so it's never a dependency.
+
+// resolveDependencies fills in RemoteStates for every account and component
+// in p: global's, then those of its siblings if it has remote_state_siblings
+// set, then those from its depends_on. Unknown references and cycles of
+// declared dependencies are errors.
+func resolveDependencies(p *Plan) error {
+	components := map[string]*Component{}
+	addComponent := func(address string, c Component) {
+		components[address] = &c
+	}
+	addComponent("global", p.Global)
+	for accountName, account := range p.Accounts {
+		for name, c := range account.Components {
+			addComponent(fmt.Sprintf("accounts/%s/%s", accountName, name), c)
+		}
+	}
+	for envName, env := range p.Envs {
+		for name, c := range env.Components {
+			addComponent(fmt.Sprintf("envs/%s/%s", envName, name), c)
+		}
+	}
+
+	addresses := make([]string, 0, len(components))
+	for address := range components {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var errs *multierror.Error
+	edges := map[string][]string{}
+	for _, address := range addresses {
+		c := components[address]
+		scope := address[:strings.LastIndex(address, "/")+1]
+
+		var refs []string
+		if c.RemoteStateSiblings {
+			refs = append(refs, c.OtherComponents...)
+			sort.Strings(refs)
+		}
+		// siblings all read each other, so only declared dependencies can
+		// make a cycle
+		siblings := len(refs)
+		refs = append(refs, c.DependsOn...)
+
+		c.RemoteStates = nil
+		if address != "global" {
+			c.RemoteStates = append(c.RemoteStates, remoteState(p, "global", "global"))
+		}
+		seen := map[string]bool{}
+		for i, ref := range refs {
+			target, name, e := resolveReference(p, scope, ref)
+			if e != nil {
+				errs = multierror.Append(errs, errors.Wrapf(e, "%s depends_on %s", address, ref))
+				continue
+			}
+			if target == address {
+				errs = multierror.Append(errs, errors.Errorf("%s depends_on itself", address))
+				continue
+			}
+			if _, ok := components[target]; ok && i >= siblings {
+				edges[address] = append(edges[address], target)
+			}
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+			c.RemoteStates = append(c.RemoteStates, remoteState(p, target, name))
+		}
+	}
+	errs = multierror.Append(errs, findCycles(addresses, edges)...)
+	if e := errs.ErrorOrNil(); e != nil {
+		return e
+	}
+
+	p.Global = *components["global"]
+	for accountName, account := range p.Accounts {
+		account.RemoteStates = []RemoteState{remoteState(p, "global", "global")}
+		for name := range account.Components {
+			account.Components[name] = *components[fmt.Sprintf("accounts/%s/%s", accountName, name)]
+		}
+		p.Accounts[accountName] = account
+	}
+	for envName, env := range p.Envs {
+		for name := range env.Components {
+			env.Components[name] = *components[fmt.Sprintf("envs/%s/%s", envName, name)]
+		}
+	}
+	return nil
+}
+
+// resolveReference turns ref into the address of what it points at, along
+// with the name to give its remote state. scope is the address prefix of the
+// referencing component's siblings, global has none.
+func resolveReference(p *Plan, scope, ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	switch {
+	case len(parts) == 1 && ref != "":
+		if scope == "" {
+			return "", "", errors.New("global has no sibling components, use envs/<env>/<component> or accounts/<account>")
+		}
+		if !hasComponent(p, scope+ref) {
+			return "", "", errors.New("not a sibling component")
+		}
+		return scope + ref, ref, nil
+	case parts[0] == "envs" && len(parts) == 3:
+		if _, ok := p.Envs[parts[1]]; !ok {
+			return "", "", errors.Errorf("%s is not a defined env", parts[1])
+		}
+	case parts[0] == "accounts" && len(parts) == 2:
+		if _, ok := p.Accounts[parts[1]]; !ok {
+			return "", "", errors.Errorf("%s is not a defined account", parts[1])
+		}
+		return ref, strings.Join(parts, "_"), nil
+	case parts[0] == "accounts" && len(parts) == 3:
+		if _, ok := p.Accounts[parts[1]]; !ok {
+			return "", "", errors.Errorf("%s is not a defined account", parts[1])
+		}
+	case ref == "global":
+		return "", "", errors.New("global's remote state is always available")
+	default:
+		return "", "", errors.New("should be <component>, envs/<env>/<component>, accounts/<account> or accounts/<account>/<component>")
+	}
+	if !hasComponent(p, ref) {
+		return "", "", errors.Errorf("%s is not a component of %s", parts[2], strings.Join(parts[:2], "/"))
+	}
+	return ref, strings.Join(parts, "_"), nil
+}
+
+func hasComponent(p *Plan, address string) bool {
+	parts := strings.Split(address, "/")
+	if len(parts) != 3 {
+		return false
+	}
+	var ok bool
+	switch parts[0] {
+	case "envs":
+		_, ok = p.Envs[parts[1]].Components[parts[2]]
+	case "accounts":
+		_, ok = p.Accounts[parts[1]].Components[parts[2]]
+	}
+	return ok
+}
+
+// remoteState is the remote state for address, read from wherever its
+// backend config in the templates puts it.
+func remoteState(p *Plan, address, name string) RemoteState {
+	parts := strings.Split(address, "/")
+	var backend AWSConfiguration
+	var key string
+	switch {
+	case address == "global":
+		backend = p.Global.AWSConfiguration
+		key = fmt.Sprintf("terraform/%s/global.tfstate", p.Global.Project)
+	case len(parts) == 2:
+		a := p.Accounts[parts[1]]
+		backend = a.AWSConfiguration
+		key = fmt.Sprintf("terraform/%s/accounts/%s.tfstate", a.Project, parts[1])
+	case parts[0] == "accounts":
+		c := p.Accounts[parts[1]].Components[parts[2]]
+		backend = c.AWSConfiguration
+		key = fmt.Sprintf("terraform/%s/accounts/%s/components/%s.tfstate", c.Project, parts[1], parts[2])
+	default:
+		c := p.Envs[parts[1]].Components[parts[2]]
+		backend = c.AWSConfiguration
+		key = fmt.Sprintf("terraform/%s/envs/%s/components/%s.tfstate", c.Project, parts[1], parts[2])
+	}
+	return RemoteState{
+		Name:    name,
+		Bucket:  backend.InfraBucket,
+		Key:     key,
+		Region:  backend.AWSRegionBackend,
+		Profile: backend.AWSProfileBackend,
+	}
+}
+
+// findCycles reports each dependency cycle once, starting from the first
+// address in it.
+func findCycles(addresses []string, edges map[string][]string) []error {
+	var errs []error
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(address string)
+	visit = func(address string) {
+		state[address] = visiting
+		stack = append(stack, address)
+		for _, next := range edges[address] {
+			switch state[next] {
+			case visiting:
+				var cycle []string
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == next {
+						cycle = append(cycle, stack[i:]...)
+						break
+					}
+				}
+				cycle = append(cycle, next)
+				errs = append(errs, errors.Errorf("dependency cycle: %s", strings.Join(cycle, " -> ")))
+			case 0:
+				visit(next)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[address] = done
+	}
+	for _, address := range addresses {
+		if state[address] == 0 {
+			visit(address)
+		}
+	}
+	return errs
+}
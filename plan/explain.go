@@ -0,0 +1,225 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chanzuckerberg/fogg/config"
+	"github.com/pkg/errors"
+)
+
+// Origin is a place in the config that a resolved value came from.
+type Origin struct {
+	// Level is defaults, account, env, component or global
+	Level string
+	// Path is the dotted path to the key, like envs.staging.owner
+	Path string
+	Pos  config.Pos
+}
+
+func (o Origin) String() string {
+	return fmt.Sprintf("%s %s (%s)", o.Level, o.Path, o.Pos)
+}
+
+// Explanation is a resolved setting along with where it came from.
+type Explanation struct {
+	// Key is the config key, extra_vars are listed one per variable as
+	// extra_vars.<name>
+	Key   string
+	Value interface{}
+	// Origins is empty for settings nothing sets. It has more than one entry
+	// for maps that were merged across levels, the last one wins.
+	Origins []Origin
+}
+
+// level is one step of the inheritance chain: defaults, an account, an env,
+// a component or global, along with where it is in the config.
+type level struct {
+	name string
+	path string
+}
+
+// origins are where the resolved settings of something came from, by config
+// key, recorded as it's resolved. Settings nothing sets have none, extra_vars
+// maps that were merged over maps have one for each level.
+type origins map[string][]Origin
+
+func (o origins) copy() origins {
+	c := make(origins, len(o))
+	for key, v := range o {
+		c[key] = v
+	}
+	return c
+}
+
+func (l level) origin(key string) Origin {
+	return Origin{Level: l.name, Path: l.path + "." + key}
+}
+
+// override records l as where the keys that are set came from.
+func (o origins) override(l level, set map[string]bool) {
+	for key, ok := range set {
+		if ok {
+			o[key] = []Origin{l.origin(key)}
+		}
+	}
+}
+
+// overrideExtraVars records l as where the variables in override came from.
+// Maps that resolveExtraVars merges over the ones in def keep their origins.
+func (o origins) overrideExtraVars(l level, def, override map[string]config.ExtraVar) {
+	for name, v := range override {
+		key := "extra_vars." + name
+		existing, ok := def[name]
+		if ok && mergesOver(existing.Default, v.Default) {
+			// copied so that what's inherited isn't changed
+			o[key] = append(append([]Origin{}, o[key]...), l.origin(key))
+			continue
+		}
+		o[key] = []Origin{l.origin(key)}
+	}
+}
+
+// at is the origins of key, with their positions in conf.
+func (o origins) at(conf *config.Config, key string) []Origin {
+	var at []Origin
+	for _, origin := range o[key] {
+		origin.Pos = conf.Position(origin.Path)
+		at = append(at, origin)
+	}
+	return at
+}
+
+// Explain lists the resolved settings of the thing at address, one of
+// global, accounts/<account>, accounts/<account>/<component>, envs/<env> or
+// envs/<env>/<component>, and says which level of the config each one came
+// from. p is the plan for conf.
+func Explain(conf *config.Config, p *Plan, address string) ([]Explanation, error) {
+	resolved, from, e := resolvedAt(conf, p, address)
+	if e != nil {
+		return nil, e
+	}
+
+	var explanations []Explanation
+	t := reflect.TypeOf(config.Component{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		if key == "extra_vars" {
+			extraVars := resolved.FieldByName("ExtraVars").Interface().(map[string]config.ExtraVar)
+			for name, v := range extraVars {
+				key := "extra_vars." + name
+				explanations = append(explanations, Explanation{Key: key, Value: v.Default, Origins: from.at(conf, key)})
+			}
+			continue
+		}
+
+		name := f.Name
+		if name == "Account" {
+			if strings.HasPrefix(address, "accounts/") {
+				// accounts and their components can't name another account
+				continue
+			}
+			// the plan calls the account a component inherits from AccountName
+			name = "AccountName"
+		}
+		value := resolved.FieldByName(name)
+		if !value.IsValid() {
+			// not a setting of this kind of thing
+			continue
+		}
+		explanations = append(explanations, Explanation{Key: key, Value: value.Interface(), Origins: from.at(conf, key)})
+	}
+	sort.SliceStable(explanations, func(i, j int) bool {
+		return explanations[i].Key < explanations[j].Key
+	})
+	return explanations, nil
+}
+
+// resolvedAt finds the thing at address in p, along with the origins of its
+// settings.
+func resolvedAt(conf *config.Config, p *Plan, address string) (reflect.Value, origins, error) {
+	parts := strings.Split(address, "/")
+	switch {
+	case address == "global":
+		return reflect.ValueOf(p.Global), p.Global.origins, nil
+
+	case parts[0] == "accounts" && (len(parts) == 2 || len(parts) == 3):
+		a, ok := p.Accounts[parts[1]]
+		if !ok {
+			return reflect.Value{}, nil, errors.Errorf("%s is not a defined account", parts[1])
+		}
+		if len(parts) == 2 {
+			return reflect.ValueOf(a), a.origins, nil
+		}
+		c, ok := a.Components[parts[2]]
+		if !ok {
+			return reflect.Value{}, nil, errors.Errorf("%s is not a component of account %s", parts[2], parts[1])
+		}
+		return reflect.ValueOf(c), c.origins, nil
+
+	case parts[0] == "envs" && (len(parts) == 2 || len(parts) == 3):
+		env, ok := p.Envs[parts[1]]
+		if !ok {
+			if _, ok := conf.Envs[parts[1]]; ok {
+				return reflect.Value{}, nil, errors.Errorf("env %s is abstract, it isn't generated", parts[1])
+			}
+			return reflect.Value{}, nil, errors.Errorf("unknown env %s", parts[1])
+		}
+		if len(parts) == 2 {
+			return reflect.ValueOf(env), env.origins, nil
+		}
+		c, ok := env.Components[parts[2]]
+		if !ok {
+			return reflect.Value{}, nil, errors.Errorf("%s is not a component of env %s", parts[2], parts[1])
+		}
+		return reflect.ValueOf(c), c.origins, nil
+	}
+	return reflect.Value{}, nil, errors.Errorf("%s should be global, accounts/<account>, accounts/<account>/<component>, envs/<env> or envs/<env>/<component>", address)
+}
+
+// PrintExplanations writes explanations as a table, values as JSON.
+func PrintExplanations(w io.Writer, address string, explanations []Explanation) error {
+	fmt.Fprintf(w, "%s:\n", address)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, explanation := range explanations {
+		value := "-"
+		if !isNil(explanation.Value) {
+			b, e := json.Marshal(explanation.Value)
+			if e != nil {
+				return errors.Wrapf(e, "unable to print %s", explanation.Key)
+			}
+			value = string(b)
+		}
+		origin := "unset"
+		if len(explanation.Origins) > 0 {
+			var origins []string
+			for i := len(explanation.Origins) - 1; i >= 0; i-- {
+				origins = append(origins, explanation.Origins[i].String())
+			}
+			origin = strings.Join(origins, ", merged over ")
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", explanation.Key, value, origin)
+	}
+	return tw.Flush()
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package plan
+
+import (
+	"strings"
+
+	"github.com/chanzuckerberg/fogg/config"
+	"github.com/pkg/errors"
+)
+
+// envConfig is an env's config along with the envs it extends, as the levels
+// it's resolved through.
+type envConfig struct {
+	// Abstract isn't inherited
+	Abstract bool
+	// Account is the account named by the env or the closest env it extends
+	Account *string
+	// Envs is the env and the envs it extends, furthest ancestor first
+	Envs []envLevel
+	// Components are the env's components, each with its config in every env
+	// of Envs that has it, in the same order
+	Components map[string][]componentLevel
+}
+
+type envLevel struct {
+	name string
+	conf config.Env
+}
+
+type componentLevel struct {
+	env  string
+	conf *config.Component
+}
+
+// resolveEnvConfig follows the extends chain of an env. A component set to
+// null in an env is removed, along with what the envs before it gave it.
+func resolveEnvConfig(conf *config.Config, name string) (envConfig, error) {
+	chain, e := envChain(conf, name)
+	if e != nil {
+		return envConfig{}, e
+	}
+	resolved := envConfig{Components: map[string][]componentLevel{}}
+	for _, envName := range chain {
+		env := conf.Envs[envName]
+		resolved.Abstract = env.Abstract
+		resolved.Account = resolveOptionalString(resolved.Account, env.Account)
+		resolved.Envs = append(resolved.Envs, envLevel{envName, env})
+		for componentName, c := range env.Components {
+			if c == nil {
+				delete(resolved.Components, componentName)
+				continue
+			}
+			resolved.Components[componentName] = append(resolved.Components[componentName], componentLevel{envName, c})
+		}
+	}
+	return resolved, nil
+}
+
+// componentAccount is the account a component names in the closest env that
+// names one, if any.
+func componentAccount(levels []componentLevel) *string {
+	var account *string
+	for _, l := range levels {
+		account = resolveOptionalString(account, l.conf.Account)
+	}
+	return account
+}
+
+// envChain lists the envs name extends, from the furthest ancestor to name.
+func envChain(conf *config.Config, name string) ([]string, error) {
+	var chain []string
+	seen := map[string]bool{}
+	for current := &name; current != nil; {
+		if seen[*current] {
+			return nil, errors.Errorf("env %s extends itself: %s", name, strings.Join(append(chain, *current), " -> "))
+		}
+		env, ok := conf.Envs[*current]
+		if !ok && len(chain) == 0 {
+			return nil, errors.Errorf("unknown env %s", *current)
+		}
+		if !ok {
+			return nil, errors.Errorf("env %s extends %s, which is not a defined env", chain[len(chain)-1], *current)
+		}
+		seen[*current] = true
+		chain = append(chain, *current)
+		current = env.Extends
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
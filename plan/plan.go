@@ -2,6 +2,7 @@ package plan
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/chanzuckerberg/fogg/config"
 	"github.com/chanzuckerberg/fogg/plugins"
@@ -15,61 +16,81 @@ const (
 )
 
 type AWSConfiguration struct {
-	AccountID          *int64
-	AccountName        string
-	AWSProfileBackend  string
-	AWSProfileProvider string
-	AWSProviderVersion string
-	AWSRegionBackend   string
-	AWSRegionProvider  string
-	AWSRegions         []string
-	InfraBucket        string
+	AccountID          *string  `json:"account_id"`
+	AccountName        string   `json:"account_name"`
+	AWSProfileBackend  string   `json:"aws_profile_backend"`
+	AWSProfileProvider string   `json:"aws_profile_provider"`
+	AWSProviderVersion string   `json:"aws_provider_version"`
+	AWSRegionBackend   string   `json:"aws_region_backend"`
+	AWSRegionProvider  string   `json:"aws_region_provider"`
+	AWSRegions         []string `json:"aws_regions"`
+	InfraBucket        string   `json:"infra_s3_bucket"`
 }
 
 type account struct {
-	AllAccounts map[string]int64
+	AllAccounts map[string]string `json:"all_accounts"`
 	AWSConfiguration
-	DockerImageVersion string
-	ExtraVars          map[string]string
-	Owner              string
-	Project            string
-	TerraformVersion   string
+	Components         map[string]Component       `json:"components"`
+	DockerImageVersion string                     `json:"docker_image_version"`
+	ExtraVars          map[string]config.ExtraVar `json:"extra_vars"`
+	Owner              string                     `json:"owner"`
+	Project            string                     `json:"project"`
+	// RemoteStates are the states the account reads, which is only global's
+	RemoteStates        []RemoteState `json:"remote_states"`
+	RemoteStateSiblings bool          `json:"remote_state_siblings"`
+	Source              string        `json:"source"`
+	TerraformVersion    string        `json:"terraform_version"`
+
+	origins origins
 }
 
 type Module struct {
-	DockerImageVersion string
-	TerraformVersion   string
+	DockerImageVersion string `json:"docker_image_version"`
+	TerraformVersion   string `json:"terraform_version"`
 }
 
 type Component struct {
 	AWSConfiguration
 
-	Component          string
-	DockerImageVersion string
-	Env                string
-	ExtraVars          map[string]string
-	ModuleSource       *string
-	OtherComponents    []string
-	Owner              string
-	Project            string
-	TerraformVersion   string
+	// Account is set for components that belong to an account rather than an env
+	Account            string                     `json:"account"`
+	Component          string                     `json:"component"`
+	DependsOn          []string                   `json:"depends_on"`
+	DockerImageVersion string                     `json:"docker_image_version"`
+	Env                string                     `json:"env"`
+	ExtraVars          map[string]config.ExtraVar `json:"extra_vars"`
+	ModuleSource       *string                    `json:"module_source"`
+	OtherComponents    []string                   `json:"other_components"`
+	Owner              string                     `json:"owner"`
+	Project            string                     `json:"project"`
+	// RemoteStates are the states the component reads: global's, then its
+	// siblings' if RemoteStateSiblings is set, then those from DependsOn
+	RemoteStates        []RemoteState `json:"remote_states"`
+	RemoteStateSiblings bool          `json:"remote_state_siblings"`
+	Source              string        `json:"source"`
+	TerraformVersion    string        `json:"terraform_version"`
+
+	origins origins
 }
 
 type Env struct {
 	AWSConfiguration
-	Components         map[string]Component
-	DockerImageVersion string
-	Env                string
-	ExtraVars          map[string]string
-	Owner              string
-	Project            string
-	TerraformVersion   string
+	Components         map[string]Component       `json:"components"`
+	DockerImageVersion string                     `json:"docker_image_version"`
+	Env                string                     `json:"env"`
+	ExtraVars          map[string]config.ExtraVar `json:"extra_vars"`
+	Owner              string                     `json:"owner"`
+	Project            string                     `json:"project"`
+	Source             string                     `json:"source"`
+	TerraformVersion   string                     `json:"terraform_version"`
+
+	origins origins
 }
 
 // Plugins contains a plan around plugins
 type Plugins struct {
-	CustomPlugins      map[string]*plugins.CustomPlugin
-	TerraformProviders map[string]*plugins.CustomPlugin
+	CustomPlugins      map[string]*plugins.CustomPlugin `json:"custom_plugins"`
+	TerraformProviders map[string]*plugins.CustomPlugin `json:"terraform_providers"`
 }
 
 // SetCustomPluginsPlan determines the plan for customPlugins
@@ -89,12 +110,12 @@ func (p *Plugins) SetTerraformProvidersPlan(terraformProviders map[string]*plugi
 }
 
 type Plan struct {
-	Accounts map[string]account
-	Envs     map[string]Env
-	Global   Component
-	Modules  map[string]Module
-	Plugins  Plugins
-	Version  string
+	Accounts map[string]account `json:"accounts"`
+	Envs     map[string]Env     `json:"envs"`
+	Global   Component          `json:"global"`
+	Modules  map[string]Module  `json:"modules"`
+	Plugins  Plugins            `json:"plugins"`
+	Version  string             `json:"version"`
 }
 
 func Eval(config *config.Config, verbose bool) (*Plan, error) {
@@ -113,18 +134,23 @@ func Eval(config *config.Config, verbose bool) (*Plan, error) {
 	}
 	p.Accounts = accounts
 
-	envs, err := buildEnvs(config)
+	envs, err := buildEnvs(config, accounts)
 	if err != nil {
 		return nil, err
 	}
 	p.Envs = envs
 
-	global, err := buildGlobal(config)
+	global, err := buildGlobal(config, accounts)
 	if err != nil {
 		return nil, err
 	}
 	p.Global = global
 
+	err = resolveDependencies(p)
+	if err != nil {
+		return nil, err
+	}
+
 	modules, err := buildModules(config)
 	if err != nil {
 		return nil, err
@@ -133,136 +159,53 @@ func Eval(config *config.Config, verbose bool) (*Plan, error) {
 	return p, nil
 }
 
-func Print(p *Plan) error {
-	fmt.Printf("Version: %s\n", p.Version)
-	fmt.Printf("fogg version: %s\n", p.Version)
-	fmt.Println("Accounts:")
-	for name, account := range p.Accounts {
-		fmt.Printf("\t%s:\n", name)
-		if account.AccountID != nil {
-			fmt.Printf("\t\taccount id: %d\n", account.AccountID)
-		}
-		fmt.Printf("\t\taccount_id: %d\n", account.AccountID)
-
-		fmt.Printf("\t\taws_profile_backend: %v\n", account.AWSProfileBackend)
-		fmt.Printf("\t\taws_profile_provider: %v\n", account.AWSProfileProvider)
-		fmt.Printf("\t\taws_provider_version: %v\n", account.AWSProviderVersion)
-		fmt.Printf("\t\taws_region_backend: %v\n", account.AWSRegionBackend)
-		fmt.Printf("\t\taws_region_provider: %v\n", account.AWSRegionProvider)
-		fmt.Printf("\t\taws_regions: %v\n", account.AWSRegions)
-		fmt.Printf("\t\tinfra_bucket: %v\n", account.InfraBucket)
-		fmt.Printf("\t\tname: %v\n", account.AccountName)
-		fmt.Printf("\t\towner: %v\n", account.Owner)
-		fmt.Printf("\t\tproject: %v\n", account.Project)
-		fmt.Printf("\t\tterraform_version: %v\n", account.TerraformVersion)
-
-		fmt.Printf("\t\tall_accounts:\n")
-		for acct, id := range account.AllAccounts {
-			fmt.Printf("\t\t\t%s: %d\n", acct, id)
-		}
-
-	}
-
-	fmt.Println("Global:")
-	fmt.Printf("\taccount_id: %d\n", p.Global.AccountID)
-	fmt.Printf("\taws_profile_backend: %v\n", p.Global.AWSProfileBackend)
-	fmt.Printf("\taws_profile_provider: %v\n", p.Global.AWSProfileProvider)
-	fmt.Printf("\taws_provider_version: %v\n", p.Global.AWSProviderVersion)
-	fmt.Printf("\taws_region_backend: %v\n", p.Global.AWSRegionBackend)
-	fmt.Printf("\taws_region_provider: %v\n", p.Global.AWSRegionProvider)
-	fmt.Printf("\taws_regions: %v\n", p.Global.AWSRegions)
-	fmt.Printf("\tinfra_bucket: %v\n", p.Global.InfraBucket)
-	fmt.Printf("\tname: %v\n", p.Global.AccountName)
-	fmt.Printf("\tother_p.Globals: %v\n", p.Global.OtherComponents)
-	fmt.Printf("\towner: %v\n", p.Global.Owner)
-	fmt.Printf("\tproject: %v\n", p.Global.Project)
-	fmt.Printf("\tterraform_version: %v\n", p.Global.TerraformVersion)
-
-	fmt.Println("Plugins:")
-	for name, customPlugin := range p.Plugins.CustomPlugins {
-		fmt.Printf("\t%s:\n", name)
-		fmt.Printf("\t\turl: %s\n", customPlugin.URL)
-		fmt.Printf("\t\tformat: %s\n", customPlugin.Format)
-	}
-	for name, customProvider := range p.Plugins.TerraformProviders {
-		fmt.Printf("\t%s:\n", name)
-		fmt.Printf("\t\turl: %s\n", customProvider.URL)
-		fmt.Printf("\t\tformat: %s\n", customProvider.Format)
-	}
-
-	fmt.Println("Envs:")
-
-	for name, env := range p.Envs {
-		fmt.Printf("\t%s:\n", name)
-		fmt.Printf("\t\taccount_id: %d\n", env.AccountID)
-
-		fmt.Printf("\t\taws_profile_backend: %v\n", env.AWSProfileBackend)
-		fmt.Printf("\t\taws_profile_provider: %v\n", env.AWSProfileProvider)
-		fmt.Printf("\t\taws_provider_version: %v\n", env.AWSProviderVersion)
-		fmt.Printf("\t\taws_region_backend: %v\n", env.AWSRegionBackend)
-		fmt.Printf("\t\taws_region_provider: %v\n", env.AWSRegionProvider)
-		fmt.Printf("\t\taws_regions: %v\n", env.AWSRegions)
-		fmt.Printf("\t\tenv: %v\n", env.Env)
-		fmt.Printf("\t\tinfra_bucket: %v\n", env.InfraBucket)
-		fmt.Printf("\t\tname: %v\n", env.AccountName)
-		fmt.Printf("\t\towner: %v\n", env.Owner)
-		fmt.Printf("\t\tproject: %v\n", env.Project)
-		fmt.Printf("\t\tterraform_version: %v\n", env.TerraformVersion)
-
-		fmt.Println("\t\tComponents:")
-
-		for name, component := range env.Components {
-			fmt.Printf("\t\t\t%s:\n", name)
-			fmt.Printf("\t\t\t\taccount_id: %d\n", component.AccountID)
-
-			fmt.Printf("\t\t\t\taws_profile_backend: %v\n", component.AWSProfileBackend)
-			fmt.Printf("\t\t\t\taws_profile_provider: %v\n", component.AWSProfileProvider)
-			fmt.Printf("\t\t\t\taws_provider_version: %v\n", component.AWSProviderVersion)
-			fmt.Printf("\t\t\t\taws_region_backend: %v\n", component.AWSRegionBackend)
-			fmt.Printf("\t\t\t\taws_region_provider: %v\n", component.AWSRegionProvider)
-			fmt.Printf("\t\t\t\taws_regions: %v\n", component.AWSRegions)
-			fmt.Printf("\t\t\t\tinfra_bucket: %v\n", component.InfraBucket)
-			fmt.Printf("\t\t\t\tname: %v\n", component.AccountName)
-			fmt.Printf("\t\t\t\tother_components: %v\n", component.OtherComponents)
-			fmt.Printf("\t\t\t\towner: %v\n", component.Owner)
-			fmt.Printf("\t\t\t\tproject: %v\n", component.Project)
-			fmt.Printf("\t\t\t\tterraform_version: %v\n", component.TerraformVersion)
-		}
-
-	}
-
-	fmt.Println("Modules:")
-	for name, module := range p.Modules {
-		fmt.Printf("\t%s:\n", name)
-		fmt.Printf("\t\tterraform_version: %s\n", module.TerraformVersion)
-	}
-	return nil
-}
-
 func buildAccounts(c *config.Config) (map[string]account, error) {
-	defaults := c.Defaults
-
 	accountPlans := make(map[string]account, len(c.Accounts))
-	for name, config := range c.Accounts {
+	for name, accountConf := range c.Accounts {
+		resolved := buildComponent(defaultsParent(c), &config.Component{
+			AccountID:           accountConf.AccountID,
+			AWSProfileBackend:   accountConf.AWSProfileBackend,
+			AWSProfileProvider:  accountConf.AWSProfileProvider,
+			AWSProviderVersion:  accountConf.AWSProviderVersion,
+			AWSRegionBackend:    accountConf.AWSRegionBackend,
+			AWSRegionProvider:   accountConf.AWSRegionProvider,
+			AWSRegions:          accountConf.AWSRegions,
+			ExtraVars:           accountConf.ExtraVars,
+			InfraBucket:         accountConf.InfraBucket,
+			Owner:               accountConf.Owner,
+			Project:             accountConf.Project,
+			RemoteStateSiblings: accountConf.RemoteStateSiblings,
+			TerraformVersion:    accountConf.TerraformVersion,
+		}, level{"account", "accounts." + name})
+
 		accountPlan := account{}
 		accountPlan.DockerImageVersion = dockerImageVersion
-
+		accountPlan.AWSConfiguration = resolved.AWSConfiguration
 		accountPlan.AccountName = name
-		accountPlan.AccountID = resolveOptionalInt(c.Defaults.AccountID, config.AccountID)
-
-		accountPlan.AWSRegionBackend = resolveRequired(defaults.AWSRegionBackend, config.AWSRegionBackend)
-		accountPlan.AWSRegionProvider = resolveRequired(defaults.AWSRegionProvider, config.AWSRegionProvider)
-		accountPlan.AWSRegions = resolveStringArray(defaults.AWSRegions, config.AWSRegions)
-
-		accountPlan.AWSProfileBackend = resolveRequired(defaults.AWSProfileBackend, config.AWSProfileBackend)
-		accountPlan.AWSProfileProvider = resolveRequired(defaults.AWSProfileProvider, config.AWSProfileProvider)
-		accountPlan.AWSProviderVersion = resolveRequired(defaults.AWSProviderVersion, config.AWSProviderVersion)
 		accountPlan.AllAccounts = resolveAccounts(c.Accounts)
-		accountPlan.TerraformVersion = resolveRequired(defaults.TerraformVersion, config.TerraformVersion)
-		accountPlan.InfraBucket = resolveRequired(defaults.InfraBucket, config.InfraBucket)
-		accountPlan.Owner = resolveRequired(defaults.Owner, config.Owner)
-		accountPlan.Project = resolveRequired(defaults.Project, config.Project)
-		accountPlan.ExtraVars = resolveExtraVars(defaults.ExtraVars, config.ExtraVars)
+		accountPlan.TerraformVersion = resolved.TerraformVersion
+		accountPlan.Owner = resolved.Owner
+		accountPlan.Project = resolved.Project
+		accountPlan.ExtraVars = resolved.ExtraVars
+		accountPlan.RemoteStateSiblings = resolved.RemoteStateSiblings
+		accountPlan.Source = c.Source("accounts." + name)
+		accountPlan.origins = resolved.origins
+
+		componentNames := make([]string, 0, len(accountConf.Components))
+		for componentName := range accountConf.Components {
+			componentNames = append(componentNames, componentName)
+		}
+		accountPlan.Components = make(map[string]Component, len(accountConf.Components))
+		for componentName, componentConf := range accountConf.Components {
+			path := fmt.Sprintf("accounts.%s.components.%s", name, componentName)
+			componentPlan := buildComponent(accountPlan.parent(), componentConf, level{"component", path})
+			componentPlan.Account = name
+			componentPlan.Component = componentName
+			componentPlan.OtherComponents = otherComponentNames(componentNames, componentName)
+			componentPlan.Source = c.Source(path)
+
+			accountPlan.Components[componentName] = componentPlan
+		}
 
 		accountPlans[name] = accountPlan
 	}
@@ -288,84 +231,170 @@ func newEnvPlan() Env {
 	return ep
 }
 
-func buildGlobal(conf *config.Config) (Component, error) {
-	// Global just uses defaults because that's the way sicc worked. We should make it directly configurable.
-	componentPlan := Component{}
-
-	componentPlan.DockerImageVersion = dockerImageVersion
-	componentPlan.AccountID = conf.Defaults.AccountID
+// parent returns the settings the account passes on to the envs and
+// components that belong to it.
+func (a account) parent() Component {
+	return Component{
+		AWSConfiguration:    a.AWSConfiguration,
+		ExtraVars:           a.ExtraVars,
+		Owner:               a.Owner,
+		Project:             a.Project,
+		RemoteStateSiblings: a.RemoteStateSiblings,
+		TerraformVersion:    a.TerraformVersion,
+		origins:             a.origins,
+	}
+}
 
-	componentPlan.AWSRegionBackend = conf.Defaults.AWSRegionBackend
-	componentPlan.AWSRegionProvider = conf.Defaults.AWSRegionProvider
-	componentPlan.AWSRegions = conf.Defaults.AWSRegions
+// defaultsParent returns the settings in defaults, for things that don't
+// belong to an account.
+func defaultsParent(conf *config.Config) Component {
+	defaults := conf.Defaults
+	parent := Component{
+		ExtraVars:           defaults.ExtraVars,
+		Owner:               defaults.Owner,
+		Project:             defaults.Project,
+		RemoteStateSiblings: defaults.RemoteStateSiblings,
+		TerraformVersion:    defaults.TerraformVersion,
+	}
+	parent.AccountID = defaults.AccountID
+	parent.AWSProfileBackend = defaults.AWSProfileBackend
+	parent.AWSProfileProvider = defaults.AWSProfileProvider
+	parent.AWSProviderVersion = defaults.AWSProviderVersion
+	parent.AWSRegionBackend = defaults.AWSRegionBackend
+	parent.AWSRegionProvider = defaults.AWSRegionProvider
+	parent.AWSRegions = defaults.AWSRegions
+	parent.InfraBucket = defaults.InfraBucket
+
+	parent.origins = origins{}
+	l := level{"defaults", "defaults"}
+	parent.origins.override(l, map[string]bool{
+		"account_id":            defaults.AccountID != nil,
+		"aws_profile_backend":   defaults.AWSProfileBackend != "",
+		"aws_profile_provider":  defaults.AWSProfileProvider != "",
+		"aws_provider_version":  defaults.AWSProviderVersion != "",
+		"aws_region_backend":    defaults.AWSRegionBackend != "",
+		"aws_region_provider":   defaults.AWSRegionProvider != "",
+		"aws_regions":           defaults.AWSRegions != nil,
+		"infra_s3_bucket":       defaults.InfraBucket != "",
+		"owner":                 defaults.Owner != "",
+		"project":               defaults.Project != "",
+		"remote_state_siblings": defaults.RemoteStateSiblings,
+		"terraform_version":     defaults.TerraformVersion != "",
+	})
+	parent.origins.overrideExtraVars(l, nil, defaults.ExtraVars)
+	return parent
+}
 
-	componentPlan.AWSProfileBackend = conf.Defaults.AWSProfileBackend
-	componentPlan.AWSProfileProvider = conf.Defaults.AWSProfileProvider
-	componentPlan.AWSProviderVersion = conf.Defaults.AWSProviderVersion
-	// TODO add AccountID to defaults
-	// componentPlan.AccountID = conf.Defaults.AccountID
+// accountParent returns what an env or component inherits from: the named
+// account, or defaults when there's no account.
+func accountParent(conf *config.Config, accounts map[string]account, name *string) (Component, error) {
+	if name == nil {
+		return defaultsParent(conf), nil
+	}
+	a, ok := accounts[*name]
+	if !ok {
+		return Component{}, errors.Errorf("unknown account %s", *name)
+	}
+	return a.parent(), nil
+}
 
-	componentPlan.TerraformVersion = conf.Defaults.TerraformVersion
-	componentPlan.InfraBucket = conf.Defaults.InfraBucket
-	componentPlan.Owner = conf.Defaults.Owner
-	componentPlan.Project = conf.Defaults.Project
-	componentPlan.ExtraVars = conf.Defaults.ExtraVars
+// buildGlobal resolves the global component, which falls back to its account
+// or defaults.
+func buildGlobal(conf *config.Config, accounts map[string]account) (Component, error) {
+	global := conf.Global
+	if global == nil {
+		global = &config.Component{}
+	}
+	parent, e := accountParent(conf, accounts, global.Account)
+	if e != nil {
+		return Component{}, errors.Wrap(e, "unable to resolve global")
+	}
 
+	componentPlan := buildComponent(parent, global, level{"global", "global"})
+	componentPlan.Source = conf.Source("global")
 	componentPlan.Component = "global"
 	return componentPlan, nil
 }
 
-func buildEnvs(conf *config.Config) (map[string]Env, error) {
+// resolveEnv resolves an env's settings against parent, one env of its
+// extends chain at a time. The result is what the env's components fall back
+// to.
+func resolveEnv(parent Component, envConf envConfig) Component {
+	for _, env := range envConf.Envs {
+		parent = buildComponent(parent, &config.Component{
+			Account:             env.conf.Account,
+			AccountID:           env.conf.AccountID,
+			AWSProfileBackend:   env.conf.AWSProfileBackend,
+			AWSProfileProvider:  env.conf.AWSProfileProvider,
+			AWSProviderVersion:  env.conf.AWSProviderVersion,
+			AWSRegionBackend:    env.conf.AWSRegionBackend,
+			AWSRegionProvider:   env.conf.AWSRegionProvider,
+			AWSRegions:          env.conf.AWSRegions,
+			ExtraVars:           env.conf.ExtraVars,
+			InfraBucket:         env.conf.InfraBucket,
+			Owner:               env.conf.Owner,
+			Project:             env.conf.Project,
+			RemoteStateSiblings: env.conf.RemoteStateSiblings,
+			TerraformVersion:    env.conf.TerraformVersion,
+		}, level{"env", "envs." + env.name})
+	}
+	return parent
+}
+
+// buildEnvs resolves envs and their components. The chain is defaults, then
+// the account the env or component names, then the envs the env extends and
+// the env, then the component in each of those envs. Abstract envs are
+// skipped.
+func buildEnvs(conf *config.Config, accounts map[string]account) (map[string]Env, error) {
 	envPlans := make(map[string]Env, len(conf.Envs))
-	defaults := conf.Defaults
 
-	defaultExtraVars := defaults.ExtraVars
+	for envName := range conf.Envs {
+		envConf, e := resolveEnvConfig(conf, envName)
+		if e != nil {
+			return nil, e
+		}
+		if envConf.Abstract {
+			continue
+		}
 
-	for envName, envConf := range conf.Envs {
-		envPlan := newEnvPlan()
+		accountPlan, e := accountParent(conf, accounts, envConf.Account)
+		if e != nil {
+			return nil, errors.Wrapf(e, "unable to resolve env %s", envName)
+		}
+		parent := resolveEnv(accountPlan, envConf)
 
-		envPlan.AccountID = resolveOptionalInt(conf.Defaults.AccountID, envConf.AccountID)
-		envPlan.Env = envName
+		envPlan := newEnvPlan()
+		envPlan.AWSConfiguration = parent.AWSConfiguration
 		envPlan.DockerImageVersion = dockerImageVersion
-
-		envPlan.AWSRegionBackend = resolveRequired(defaults.AWSRegionBackend, envConf.AWSRegionBackend)
-		envPlan.AWSRegionProvider = resolveRequired(defaults.AWSRegionProvider, envConf.AWSRegionProvider)
-		envPlan.AWSRegions = resolveStringArray(defaults.AWSRegions, envConf.AWSRegions)
-
-		envPlan.AWSProfileBackend = resolveRequired(defaults.AWSProfileBackend, envConf.AWSProfileBackend)
-		envPlan.AWSProfileProvider = resolveRequired(defaults.AWSProfileProvider, envConf.AWSProfileProvider)
-		envPlan.AWSProviderVersion = resolveRequired(defaults.AWSProviderVersion, envConf.AWSProviderVersion)
-
-		envPlan.TerraformVersion = resolveRequired(defaults.TerraformVersion, envConf.TerraformVersion)
-		envPlan.InfraBucket = resolveRequired(defaults.InfraBucket, envConf.InfraBucket)
-		envPlan.Owner = resolveRequired(defaults.Owner, envConf.Owner)
-		envPlan.Project = resolveRequired(defaults.Project, envConf.Project)
-		envPlan.ExtraVars = resolveExtraVars(defaultExtraVars, envConf.ExtraVars)
-
-		for componentName, componentConf := range conf.Envs[envName].Components {
-			componentPlan := Component{}
-
-			componentPlan.AccountID = resolveOptionalInt(envPlan.AccountID, componentConf.AccountID)
-			componentPlan.AWSRegionBackend = resolveRequired(envPlan.AWSRegionBackend, componentConf.AWSRegionBackend)
-			componentPlan.AWSRegionProvider = resolveRequired(envPlan.AWSRegionProvider, componentConf.AWSRegionProvider)
-			componentPlan.AWSRegions = resolveStringArray(envPlan.AWSRegions, componentConf.AWSRegions)
-
-			componentPlan.AWSProfileBackend = resolveRequired(envPlan.AWSProfileBackend, componentConf.AWSProfileBackend)
-			componentPlan.AWSProfileProvider = resolveRequired(envPlan.AWSProfileProvider, componentConf.AWSProfileProvider)
-			componentPlan.AWSProviderVersion = resolveRequired(envPlan.AWSProviderVersion, componentConf.AWSProviderVersion)
-			componentPlan.AccountID = resolveOptionalInt(envPlan.AccountID, componentConf.AccountID)
-
-			componentPlan.TerraformVersion = resolveRequired(envPlan.TerraformVersion, componentConf.TerraformVersion)
-			componentPlan.InfraBucket = resolveRequired(envPlan.InfraBucket, componentConf.InfraBucket)
-			componentPlan.Owner = resolveRequired(envPlan.Owner, componentConf.Owner)
-			componentPlan.Project = resolveRequired(envPlan.Project, componentConf.Project)
+		envPlan.Env = envName
+		envPlan.ExtraVars = parent.ExtraVars
+		envPlan.Owner = parent.Owner
+		envPlan.Project = parent.Project
+		envPlan.Source = conf.Source("envs." + envName)
+		envPlan.TerraformVersion = parent.TerraformVersion
+		envPlan.origins = parent.origins
+
+		componentNames := make([]string, 0, len(envConf.Components))
+		for componentName := range envConf.Components {
+			componentNames = append(componentNames, componentName)
+		}
+		for componentName, levels := range envConf.Components {
+			componentPlan := parent
+			if account := componentAccount(levels); account != nil {
+				accountPlan, e := accountParent(conf, accounts, account)
+				if e != nil {
+					return nil, errors.Wrapf(e, "unable to resolve component %s in env %s", componentName, envName)
+				}
+				componentPlan = resolveEnv(accountPlan, envConf)
+			}
+			for _, l := range levels {
+				componentPlan = buildComponent(componentPlan, l.conf, level{"component", fmt.Sprintf("envs.%s.components.%s", l.env, componentName)})
+			}
 
 			componentPlan.Env = envName
 			componentPlan.Component = componentName
-			componentPlan.DockerImageVersion = dockerImageVersion
-			componentPlan.OtherComponents = otherComponentNames(conf.Envs[envName].Components, componentName)
-			componentPlan.ModuleSource = componentConf.ModuleSource
-			componentPlan.ExtraVars = resolveExtraVars(envPlan.ExtraVars, componentConf.ExtraVars)
+			componentPlan.OtherComponents = otherComponentNames(componentNames, componentName)
+			componentPlan.Source = conf.Source(fmt.Sprintf("envs.%s.components.%s", envName, componentName))
 
 			envPlan.Components[componentName] = componentPlan
 		}
@@ -375,27 +404,114 @@ func buildEnvs(conf *config.Config) (map[string]Env, error) {
 	return envPlans, nil
 }
 
-func otherComponentNames(components map[string]*config.Component, thisComponent string) []string {
+// buildComponent resolves a component against the account or env it belongs
+// to, which has already been resolved against defaults. Every level of the
+// config is resolved with it, l says which one conf is so that where each
+// setting came from is recorded.
+func buildComponent(parent Component, conf *config.Component, l level) Component {
+	if conf == nil {
+		conf = &config.Component{}
+	}
+	componentPlan := Component{}
+
+	componentPlan.AccountID = resolveOptionalString(parent.AccountID, conf.AccountID)
+	componentPlan.AccountName = parent.AccountName
+	componentPlan.AWSRegionBackend = resolveRequired(parent.AWSRegionBackend, conf.AWSRegionBackend)
+	componentPlan.AWSRegionProvider = resolveRequired(parent.AWSRegionProvider, conf.AWSRegionProvider)
+	componentPlan.AWSRegions = resolveStringArray(parent.AWSRegions, conf.AWSRegions)
+
+	componentPlan.AWSProfileBackend = resolveRequired(parent.AWSProfileBackend, conf.AWSProfileBackend)
+	componentPlan.AWSProfileProvider = resolveRequired(parent.AWSProfileProvider, conf.AWSProfileProvider)
+	componentPlan.AWSProviderVersion = resolveRequired(parent.AWSProviderVersion, conf.AWSProviderVersion)
+
+	componentPlan.TerraformVersion = resolveRequired(parent.TerraformVersion, conf.TerraformVersion)
+	componentPlan.InfraBucket = resolveRequired(parent.InfraBucket, conf.InfraBucket)
+	componentPlan.Owner = resolveRequired(parent.Owner, conf.Owner)
+	componentPlan.Project = resolveRequired(parent.Project, conf.Project)
+
+	componentPlan.DockerImageVersion = dockerImageVersion
+	componentPlan.ModuleSource = resolveOptionalString(parent.ModuleSource, conf.ModuleSource)
+	componentPlan.ExtraVars = resolveExtraVars(parent.ExtraVars, conf.ExtraVars)
+	componentPlan.DependsOn = resolveStringArray(parent.DependsOn, conf.DependsOn)
+	componentPlan.RemoteStateSiblings = resolveBool(parent.RemoteStateSiblings, conf.RemoteStateSiblings)
+
+	componentPlan.origins = parent.origins.copy()
+	componentPlan.origins.override(l, map[string]bool{
+		"account":               conf.Account != nil,
+		"account_id":            conf.AccountID != nil,
+		"aws_profile_backend":   conf.AWSProfileBackend != nil,
+		"aws_profile_provider":  conf.AWSProfileProvider != nil,
+		"aws_provider_version":  conf.AWSProviderVersion != nil,
+		"aws_region_backend":    conf.AWSRegionBackend != nil,
+		"aws_region_provider":   conf.AWSRegionProvider != nil,
+		"aws_regions":           conf.AWSRegions != nil,
+		"depends_on":            conf.DependsOn != nil,
+		"infra_s3_bucket":       conf.InfraBucket != nil,
+		"module_source":         conf.ModuleSource != nil,
+		"owner":                 conf.Owner != nil,
+		"project":               conf.Project != nil,
+		"remote_state_siblings": conf.RemoteStateSiblings != nil,
+		"terraform_version":     conf.TerraformVersion != nil,
+	})
+	componentPlan.origins.overrideExtraVars(l, parent.ExtraVars, conf.ExtraVars)
+	return componentPlan
+}
+
+func otherComponentNames(components []string, thisComponent string) []string {
 	r := make([]string, 0)
-	for componentName := range components {
+	for _, componentName := range components {
 		if componentName != thisComponent {
 			r = append(r, componentName)
 		}
 	}
+	sort.Strings(r)
 	return r
 }
 
-func resolveExtraVars(def map[string]string, override map[string]string) map[string]string {
-	resolved := map[string]string{}
+// resolveExtraVars merges override over def. Variables whose defaults are
+// maps at both levels are merged key by key, all the way down; anything else
+// in override replaces what's in def.
+func resolveExtraVars(def map[string]config.ExtraVar, override map[string]config.ExtraVar) map[string]config.ExtraVar {
+	resolved := map[string]config.ExtraVar{}
 	for k, v := range def {
 		resolved[k] = v
 	}
 	for k, v := range override {
+		if existing, ok := resolved[k]; ok {
+			v.Default = mergeValues(existing.Default, v.Default)
+			if v.Description == "" {
+				v.Description = existing.Description
+			}
+		}
 		resolved[k] = v
 	}
 	return resolved
 }
 
+func mergeValues(def interface{}, override interface{}) interface{} {
+	if !mergesOver(def, override) {
+		return override
+	}
+	defMap := def.(map[string]interface{})
+	overrideMap := override.(map[string]interface{})
+	merged := make(map[string]interface{}, len(defMap)+len(overrideMap))
+	for k, v := range defMap {
+		merged[k] = v
+	}
+	for k, v := range overrideMap {
+		merged[k] = mergeValues(merged[k], v)
+	}
+	return merged
+}
+
+// mergesOver says if override is merged over def rather than replacing it,
+// which is when they're both maps.
+func mergesOver(def interface{}, override interface{}) bool {
+	_, defIsMap := def.(map[string]interface{})
+	_, overrideIsMap := override.(map[string]interface{})
+	return defIsMap && overrideIsMap
+}
+
 func resolveStringArray(def []string, override []string) []string {
 	if override != nil {
 		return override
@@ -410,15 +526,22 @@ func resolveRequired(def string, override *string) string {
 	return def
 }
 
-func resolveOptionalInt(def *int64, override *int64) *int64 {
+func resolveBool(def bool, override *bool) bool {
+	if override != nil {
+		return *override
+	}
+	return def
+}
+
+func resolveOptionalString(def *string, override *string) *string {
 	if override != nil {
 		return override
 	}
 	return def
 }
 
-func resolveAccounts(accounts map[string]config.Account) map[string]int64 {
-	a := make(map[string]int64)
+func resolveAccounts(accounts map[string]config.Account) map[string]string {
+	a := make(map[string]string)
 	for name, account := range accounts {
 		if account.AccountID != nil {
 			a[name] = *account.AccountID
@@ -2,12 +2,16 @@ package plan
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/chanzuckerberg/fogg/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v3"
 )
 
 func init() {
@@ -27,7 +31,7 @@ func TestResolveRequired(t *testing.T) {
 }
 
 func TestResolveAccounts(t *testing.T) {
-	foo, bar := int64(123), int64(456)
+	foo, bar := "000000000123", "000000000456"
 
 	accounts := map[string]config.Account{
 		"foo": {
@@ -41,7 +45,7 @@ func TestResolveAccounts(t *testing.T) {
 
 	other := resolveAccounts(accounts)
 	assert.NotNil(t, other)
-	assert.Equal(t, map[string]int64{"bar": bar, "foo": foo}, other)
+	assert.Equal(t, map[string]string{"bar": bar, "foo": foo}, other)
 }
 
 func TestResolveStringArray(t *testing.T) {
@@ -60,6 +64,38 @@ func TestResolveStringArray(t *testing.T) {
 
 }
 
+func TestResolveExtraVars(t *testing.T) {
+	def := map[string]config.ExtraVar{
+		"tags": {Default: map[string]interface{}{
+			"team":  "infra",
+			"alarm": map[string]interface{}{"email": "infra@example.com", "pager": "yes"},
+		}, Description: "tags for everything"},
+		"cidrs": {Default: []interface{}{"10.0.0.0/16"}},
+		"size":  {Default: "small"},
+	}
+	override := map[string]config.ExtraVar{
+		"tags": {Default: map[string]interface{}{
+			"service": "db",
+			"alarm":   map[string]interface{}{"pager": "no"},
+		}},
+		"cidrs": {Default: []interface{}{"10.1.0.0/16"}},
+		"size":  {Default: map[string]interface{}{"cpu": "2"}},
+	}
+
+	result := resolveExtraVars(def, override)
+	assert.Equal(t, config.ExtraVar{Default: map[string]interface{}{
+		"team":    "infra",
+		"service": "db",
+		"alarm":   map[string]interface{}{"email": "infra@example.com", "pager": "no"},
+	}, Description: "tags for everything"}, result["tags"])
+	// lists and other types are replaced
+	assert.Equal(t, []interface{}{"10.1.0.0/16"}, result["cidrs"].Default)
+	assert.Equal(t, map[string]interface{}{"cpu": "2"}, result["size"].Default)
+
+	// the inputs are left alone
+	assert.Equal(t, "yes", def["tags"].Default.(map[string]interface{})["alarm"].(map[string]interface{})["pager"])
+}
+
 func TestPlanBasic(t *testing.T) {
 	f, _ := os.Open("fixtures/full.json")
 	defer f.Close()
@@ -108,10 +144,511 @@ func TestExtraVarsComposition(t *testing.T) {
 	assert.NotNil(t, plan)
 
 	// accts inherit defaults
-	assert.Equal(t, "bar1", plan.Accounts["foo"].ExtraVars["foo"])
+	assert.Equal(t, "bar1", plan.Accounts["foo"].ExtraVars["foo"].Default)
 	// envs overwrite defaults
-	assert.Equal(t, "bar2", plan.Envs["staging"].Components["comp1"].ExtraVars["foo"])
+	assert.Equal(t, "bar2", plan.Envs["staging"].Components["comp1"].ExtraVars["foo"].Default)
 	// component overwrite env
-	assert.Equal(t, "bar3", plan.Envs["staging"].Components["vpc"].ExtraVars["foo"])
+	assert.Equal(t, "bar3", plan.Envs["staging"].Components["vpc"].ExtraVars["foo"].Default)
+
+}
+
+func TestBuildGlobal(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	r := bufio.NewReader(f)
+	c, err := config.ReadConfig(r)
+	assert.Nil(t, err)
+
+	// without a global section everything comes from defaults
+	global, e := buildGlobal(c, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "global", global.Component)
+	assert.Equal(t, "prof", global.AWSProfileProvider)
+	assert.Equal(t, "bar1", global.ExtraVars["foo"].Default)
+	assert.Nil(t, global.ModuleSource)
+
+	profile, source := "global-prof", "terraform/modules/my_module"
+	c.Global = &config.Component{
+		AWSProfileProvider: &profile,
+		ExtraVars:          map[string]config.ExtraVar{"bar": {Default: "baz"}},
+		ModuleSource:       &source,
+	}
+	global, e = buildGlobal(c, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "global-prof", global.AWSProfileProvider)
+	assert.Equal(t, "prof", global.AWSProfileBackend)
+	assert.Equal(t, map[string]config.ExtraVar{"foo": {Default: "bar1"}, "bar": {Default: "baz"}}, global.ExtraVars)
+	assert.Equal(t, "terraform/modules/my_module", *global.ModuleSource)
+}
+
+func TestAccountComponents(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, err := config.ReadConfig(bufio.NewReader(f))
+	assert.Nil(t, err)
+	err = json.Unmarshal([]byte(`{
+  "foo": {
+    "account_id": "000000000123",
+    "aws_profile_provider": "foo",
+    "extra_vars": {"foo": "bar2"},
+    "components": {
+      "iam": {},
+      "dns": {"owner": "dns@example.com", "extra_vars": {"foo": "bar3"}}
+    }
+  }
+}`), &c.Accounts)
+	assert.Nil(t, err)
+
+	plan, e := Eval(c, true)
+	assert.Nil(t, e)
+	components := plan.Accounts["foo"].Components
+	assert.Len(t, components, 2)
+
+	iam := components["iam"]
+	assert.Equal(t, "foo", iam.Account)
+	assert.Equal(t, "iam", iam.Component)
+	assert.Equal(t, "", iam.Env)
+	assert.Equal(t, "000000000123", *iam.AccountID)
+	assert.Equal(t, "foo", iam.AWSProfileProvider)
+	assert.Equal(t, "prof", iam.AWSProfileBackend)
+	assert.Equal(t, "foo@example.com", iam.Owner)
+	assert.Equal(t, "bar2", iam.ExtraVars["foo"].Default)
+	assert.Equal(t, []string{"dns"}, iam.OtherComponents)
+
+	dns := components["dns"]
+	assert.Equal(t, "dns@example.com", dns.Owner)
+	assert.Equal(t, "bar3", dns.ExtraVars["foo"].Default)
+}
+
+func TestEnvAccounts(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, err := config.ReadConfig(bufio.NewReader(f))
+	assert.Nil(t, err)
+	err = json.Unmarshal([]byte(`{
+  "prod": {
+    "account_id": "000000000001",
+    "aws_profile_provider": "prod",
+    "infra_s3_bucket": "prod-bucket",
+    "extra_vars": {"foo": "prod"}
+  },
+  "shared": {
+    "account_id": "000000000002",
+    "aws_profile_provider": "shared"
+  }
+}`), &c.Accounts)
+	assert.Nil(t, err)
+	err = json.Unmarshal([]byte(`{
+  "prod": {
+    "account": "prod",
+    "owner": "prod@example.com",
+    "extra_vars": {"bar": "env"},
+    "components": {
+      "db": {},
+      "dns": {"account": "shared"},
+      "web": {"aws_profile_provider": "web"}
+    }
+  }
+}`), &c.Envs)
+	assert.Nil(t, err)
+	c.Defaults.ExtraVars = map[string]config.ExtraVar{"foo": {Default: "defaults"}, "bar": {Default: "defaults"}}
+
+	plan, e := Eval(c, true)
+	assert.Nil(t, e)
+
+	prod := plan.Envs["prod"]
+	assert.Equal(t, "prod", prod.AccountName)
+	assert.Equal(t, "000000000001", *prod.AccountID)
+	assert.Equal(t, "prod", prod.AWSProfileProvider)
+	assert.Equal(t, "prod-bucket", prod.InfraBucket)
+	assert.Equal(t, "prod@example.com", prod.Owner)
+	assert.Equal(t, map[string]config.ExtraVar{"foo": {Default: "prod"}, "bar": {Default: "env"}}, prod.ExtraVars)
+
+	db := prod.Components["db"]
+	assert.Equal(t, "000000000001", *db.AccountID)
+	assert.Equal(t, "prod", db.AWSProfileProvider)
+	assert.Equal(t, "prod@example.com", db.Owner)
+
+	// the component's account replaces the env's, the env's own settings
+	// still apply
+	dns := prod.Components["dns"]
+	assert.Equal(t, "shared", dns.AccountName)
+	assert.Equal(t, "000000000002", *dns.AccountID)
+	assert.Equal(t, "shared", dns.AWSProfileProvider)
+	assert.Equal(t, "buck", dns.InfraBucket)
+	assert.Equal(t, "prod@example.com", dns.Owner)
+
+	assert.Equal(t, "web", prod.Components["web"].AWSProfileProvider)
+
+	staging := plan.Envs["staging"]
+	assert.Equal(t, "", staging.AccountName)
+	assert.Nil(t, staging.AccountID)
+	assert.Equal(t, "prof", staging.AWSProfileProvider)
+
+	// plans for unvalidated configs still catch unknown accounts
+	missing := "missing"
+	env := c.Envs["staging"]
+	env.Account = &missing
+	c.Envs["staging"] = env
+	_, e = Eval(c, true)
+	assert.NotNil(t, e)
+}
+
+func TestEnvExtends(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, err := config.ReadConfig(bufio.NewReader(f))
+	assert.Nil(t, err)
+	err = json.Unmarshal([]byte(`{
+  "template": {
+    "abstract": true,
+    "owner": "template@example.com",
+    "extra_vars": {"foo": "template", "bar": "template"},
+    "components": {
+      "vpc": {"module_source": "github.com/terraform-aws-modules/terraform-aws-vpc?ref=v1.30.0"},
+      "db": {"extra_vars": {"size": "small", "engine": "postgres"}},
+      "cache": {}
+    }
+  },
+  "staging": {
+    "extends": "template",
+    "extra_vars": {"foo": "staging"},
+    "components": {
+      "db": {"extra_vars": {"size": "large"}},
+      "cache": null,
+      "web": {}
+    }
+  },
+  "qa": {
+    "extends": "staging",
+    "aws_profile_provider": "qa"
+  }
+}`), &c.Envs)
+	assert.Nil(t, err)
+
+	plan, e := Eval(c, true)
+	assert.Nil(t, e)
+	// the fixture's prod, staging and qa
+	assert.Len(t, plan.Envs, 3)
+	assert.NotContains(t, plan.Envs, "template")
+
+	staging := plan.Envs["staging"]
+	assert.Equal(t, "template@example.com", staging.Owner)
+	assert.Equal(t, map[string]config.ExtraVar{"foo": {Default: "staging"}, "bar": {Default: "template"}}, staging.ExtraVars)
+	assert.Len(t, staging.Components, 3)
+	assert.NotContains(t, staging.Components, "cache")
+	assert.Equal(t, "github.com/terraform-aws-modules/terraform-aws-vpc?ref=v1.30.0", *staging.Components["vpc"].ModuleSource)
+	assert.Equal(t, "large", staging.Components["db"].ExtraVars["size"].Default)
+	assert.Equal(t, "postgres", staging.Components["db"].ExtraVars["engine"].Default)
+	assert.ElementsMatch(t, []string{"db", "vpc"}, staging.Components["web"].OtherComponents)
+
+	qa := plan.Envs["qa"]
+	assert.Equal(t, "qa", qa.AWSProfileProvider)
+	assert.Equal(t, "qa", qa.Components["db"].AWSProfileProvider)
+	assert.Equal(t, "large", qa.Components["db"].ExtraVars["size"].Default)
+	assert.Len(t, qa.Components, 3)
+
+	// cycles are rejected
+	env := c.Envs["template"]
+	qaName := "qa"
+	env.Extends = &qaName
+	c.Envs["template"] = env
+	_, e = Eval(c, true)
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "extends itself")
+}
+
+func TestDependencies(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, err := config.ReadConfig(bufio.NewReader(f))
+	assert.Nil(t, err)
+	// the fixture is from before version 3, which turned this on
+	c.Defaults.RemoteStateSiblings = false
+	err = json.Unmarshal([]byte(`{
+  "depends_on": ["accounts/prod"],
+  "infra_s3_bucket": "globalbuck",
+  "aws_region_backend": "us-east-1"
+}`), &c.Global)
+	assert.Nil(t, err)
+	err = json.Unmarshal([]byte(`{
+  "prod": {
+    "project": "prodproj",
+    "infra_s3_bucket": "prodbuck",
+    "aws_region_backend": "us-west-2",
+    "aws_profile_backend": "prodprof",
+    "components": {"dns": {}}
+  }
+}`), &c.Accounts)
+	assert.Nil(t, err)
+	err = json.Unmarshal([]byte(`{
+  "staging": {
+    "components": {
+      "vpc": {},
+      "db": {"depends_on": ["vpc", "accounts/prod/dns"]},
+      "web": {"depends_on": ["db", "envs/shared/logs"]}
+    }
+  },
+  "shared": {
+    "infra_s3_bucket": "sharedbuck",
+    "remote_state_siblings": true,
+    "components": {
+      "logs": {},
+      "metrics": {"depends_on": ["accounts/prod"]}
+    }
+  }
+}`), &c.Envs)
+	assert.Nil(t, err)
+
+	plan, e := Eval(c, true)
+	assert.Nil(t, e)
+
+	// global is read from its own backend, whoever reads it
+	global := RemoteState{Name: "global", Bucket: "globalbuck", Key: "terraform/proj/global.tfstate", Region: "us-east-1", Profile: "prof"}
+	assert.Equal(t, []RemoteState{global}, plan.Accounts["prod"].RemoteStates)
+	assert.Equal(t, []RemoteState{global}, plan.Accounts["prod"].Components["dns"].RemoteStates)
+
+	staging := plan.Envs["staging"]
+	assert.Equal(t, []RemoteState{global}, staging.Components["vpc"].RemoteStates)
+	assert.Equal(t, []RemoteState{
+		global,
+		{Name: "vpc", Bucket: "buck", Key: "terraform/proj/envs/staging/components/vpc.tfstate", Region: "reg", Profile: "prof"},
+		{Name: "accounts_prod_dns", Bucket: "prodbuck", Key: "terraform/prodproj/accounts/prod/components/dns.tfstate", Region: "us-west-2", Profile: "prodprof"},
+	}, staging.Components["db"].RemoteStates)
+	assert.Equal(t, []RemoteState{
+		global,
+		{Name: "db", Bucket: "buck", Key: "terraform/proj/envs/staging/components/db.tfstate", Region: "reg", Profile: "prof"},
+		{Name: "envs_shared_logs", Bucket: "sharedbuck", Key: "terraform/proj/envs/shared/components/logs.tfstate", Region: "reg", Profile: "prof"},
+	}, staging.Components["web"].RemoteStates)
+
+	// global, then siblings, then declared dependencies
+	shared := plan.Envs["shared"]
+	assert.Equal(t, []RemoteState{
+		global,
+		{Name: "logs", Bucket: "sharedbuck", Key: "terraform/proj/envs/shared/components/logs.tfstate", Region: "reg", Profile: "prof"},
+		{Name: "accounts_prod", Bucket: "prodbuck", Key: "terraform/prodproj/accounts/prod.tfstate", Region: "us-west-2", Profile: "prodprof"},
+	}, shared.Components["metrics"].RemoteStates)
+
+	assert.Len(t, plan.Global.RemoteStates, 1)
+	assert.Equal(t, "accounts_prod", plan.Global.RemoteStates[0].Name)
+
+	// unknown references are rejected
+	c.Envs["staging"].Components["vpc"].DependsOn = []string{"envs/nope/vpc", "cache"}
+	_, e = Eval(c, true)
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "envs/staging/vpc depends_on envs/nope/vpc: nope is not a defined env")
+	assert.Contains(t, e.Error(), "envs/staging/vpc depends_on cache: not a sibling component")
+
+	// as are cycles
+	c.Envs["staging"].Components["vpc"].DependsOn = []string{"web"}
+	_, e = Eval(c, true)
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "dependency cycle: envs/staging/db -> envs/staging/vpc -> envs/staging/web -> envs/staging/db")
+}
+
+func TestExplain(t *testing.T) {
+	json := `{
+  "defaults": {
+    "aws_region_backend": "us-west-2",
+    "aws_region_provider": "us-west-2",
+    "aws_profile_backend": "default",
+    "aws_profile_provider": "default",
+    "aws_provider_version": "1.0.0",
+    "infra_s3_bucket": "buck",
+    "project": "proj",
+    "terraform_version": "0.100.0",
+    "owner": "foo@example.com",
+    "extra_vars": {"tags": {"team": "infra"}, "size": "small", "labels": "none"}
+  },
+  "accounts": {
+    "prod": {"aws_profile_backend": "prod"}
+  },
+  "envs": {
+    "template": {
+      "abstract": true,
+      "owner": "template@example.com",
+      "components": {"db": {"extra_vars": {"size": "medium"}}}
+    },
+    "staging": {
+      "extends": "template",
+      "account": "prod",
+      "extra_vars": {"tags": {"env": "staging"}, "labels": {"team": "db"}},
+      "components": {"db": {"aws_profile_provider": "db"}}
+    }
+  }
+}`
+	c, err := config.ReadConfig(strings.NewReader(json))
+	assert.Nil(t, err)
+	p, e := Eval(c, false)
+	assert.Nil(t, e)
+
+	explanations, e := Explain(c, p, "envs/staging/db")
+	assert.Nil(t, e)
+	byKey := map[string]Explanation{}
+	for _, explanation := range explanations {
+		byKey[explanation.Key] = explanation
+	}
+
+	origin := func(key string) string {
+		var origins []string
+		for _, o := range byKey[key].Origins {
+			origins = append(origins, o.String())
+		}
+		return strings.Join(origins, "; ")
+	}
+	assert.Equal(t, "prod", byKey["aws_profile_backend"].Value)
+	assert.Equal(t, "account accounts.prod.aws_profile_backend (15:14)", origin("aws_profile_backend"))
+	assert.Equal(t, "db", byKey["aws_profile_provider"].Value)
+	assert.Equal(t, "component envs.staging.components.db.aws_profile_provider (27:29)", origin("aws_profile_provider"))
+	assert.Equal(t, "template@example.com", byKey["owner"].Value)
+	assert.Equal(t, "env envs.template.owner (20:7)", origin("owner"))
+	assert.Equal(t, "defaults defaults.project (9:5)", origin("project"))
+	assert.Equal(t, "prod", byKey["account"].Value)
+	assert.Equal(t, "env envs.staging.account (25:7)", origin("account"))
+	assert.Equal(t, "", origin("module_source"))
+
+	assert.Equal(t, "medium", byKey["extra_vars.size"].Value)
+	assert.Equal(t, "component envs.template.components.db.extra_vars.size (21:44)", origin("extra_vars.size"))
+	assert.Equal(t, map[string]interface{}{"team": "infra", "env": "staging"}, byKey["extra_vars.tags"].Value)
+	assert.Equal(t, "defaults defaults.extra_vars.tags (12:20); env envs.staging.extra_vars.tags (26:22)", origin("extra_vars.tags"))
+	// a map replacing a string isn't merged over it
+	assert.Equal(t, map[string]interface{}{"team": "db"}, byKey["extra_vars.labels"].Value)
+	assert.Equal(t, "env envs.staging.extra_vars.labels (26:50)", origin("extra_vars.labels"))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, PrintExplanations(buf, "envs/staging/db", explanations))
+	assert.Contains(t, buf.String(), "envs/staging/db:\n")
+	assert.Regexp(t, `extra_vars.tags +\{"env":"staging","team":"infra"\} +env envs.staging.extra_vars.tags \(26:22\), merged over defaults`, buf.String())
+	assert.Regexp(t, `module_source +- +unset`, buf.String())
+
+	_, e = Explain(c, p, "envs/template/db")
+	assert.NotNil(t, e)
+	_, e = Explain(c, p, "envs/staging/nope")
+	assert.NotNil(t, e)
+	_, e = Explain(c, p, "nope")
+	assert.NotNil(t, e)
+
+	explanations, e = Explain(c, p, "accounts/prod")
+	assert.Nil(t, e)
+	for _, explanation := range explanations {
+		assert.NotEqual(t, "account", explanation.Key)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, err := config.ReadConfig(bufio.NewReader(f))
+	assert.Nil(t, err)
+	p, e := Eval(c, false)
+	assert.Nil(t, e)
+	// too big for a float64
+	p.Envs["staging"].Components["vpc"].ExtraVars["big"] = config.ExtraVar{Default: json.Number("12345678901234567891")}
+
+	print := func(format string) string {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, Print(buf, p, nil, format))
+		return buf.String()
+	}
+
+	for _, format := range []string{FormatText, FormatJSON, FormatYAML} {
+		out := print(format)
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, out, print(format), "%s output should be stable", format)
+		}
+	}
+
+	var decoded struct {
+		Accounts map[string]struct {
+			AccountID *string `json:"account_id"`
+		} `json:"accounts"`
+		Envs map[string]struct {
+			Components map[string]struct {
+				ExtraVars       map[string]interface{} `json:"extra_vars"`
+				ModuleSource    *string                `json:"module_source"`
+				OtherComponents []string               `json:"other_components"`
+			} `json:"components"`
+		} `json:"envs"`
+		Modules map[string]interface{} `json:"modules"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(print(FormatJSON)), &decoded))
+	assert.Equal(t, "000000000456", *decoded.Accounts["bar"].AccountID)
+	vpc := decoded.Envs["staging"].Components["vpc"]
+	assert.Equal(t, "bar3", vpc.ExtraVars["foo"])
+	assert.Equal(t, "github.com/terraform-aws-modules/terraform-aws-vpc?ref=v1.30.0", *vpc.ModuleSource)
+	assert.Equal(t, []string{"comp1", "comp2"}, vpc.OtherComponents)
+	assert.Contains(t, decoded.Modules, "my_module")
+	assert.Contains(t, print(FormatJSON), `"big": 12345678901234567891`)
+	assert.Contains(t, print(FormatYAML), "big: 12345678901234567891\n")
+
+	var fromYAML map[string]interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte(print(FormatYAML)), &fromYAML))
+	var fromJSON map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(print(FormatJSON)), &fromJSON))
+	assert.Equal(t, fromJSON["version"], fromYAML["version"])
+	assert.Len(t, fromYAML["envs"], 2)
+
+	text := print(FormatText)
+	assert.Contains(t, text, "accounts:\n\tbar:\n\t\taccount_id: 000000000456\n")
+	assert.Contains(t, text, "\t\t\t\tother_components: [comp1, comp2]\n")
+	assert.Contains(t, text, "\t\t\t\t\tbig: 12345678901234567891\n")
+	assert.True(t, strings.Index(text, "\tprod:") < strings.Index(text, "\tstaging:"))
+
+	assert.NotNil(t, Print(&bytes.Buffer{}, p, nil, "xml"))
+}
+
+func TestSelect(t *testing.T) {
+	f, _ := os.Open("fixtures/full.json")
+	defer f.Close()
+	c, err := config.ReadConfig(bufio.NewReader(f))
+	assert.Nil(t, err)
+	p, e := Eval(c, false)
+	assert.Nil(t, e)
+
+	s, e := Select(p, nil)
+	assert.Nil(t, e)
+	assert.True(t, s.Selected("envs/staging/vpc"))
+	assert.True(t, s.Selected("global"))
+	assert.True(t, s.Selected("plugins"))
 
+	s, e = Select(p, []string{"envs/staging/vpc", "terraform/accounts/foo/", "envs/*/comp?"})
+	assert.Nil(t, e)
+	assert.True(t, s.Selected("envs/staging/vpc"))
+	assert.True(t, s.Selected("envs/staging/comp1"))
+	assert.False(t, s.Selected("envs/staging"))
+	assert.True(t, s.Contains("envs/staging"))
+	assert.False(t, s.Contains("envs/prod"))
+	assert.True(t, s.Selected("accounts/foo"))
+	assert.False(t, s.Contains("accounts/bar"))
+	assert.False(t, s.Selected("global"))
+	assert.False(t, s.Selected("modules/my_module"))
+	assert.False(t, s.Selected("plugins"))
+
+	s, e = Select(p, []string{"plugins"})
+	assert.Nil(t, e)
+	assert.True(t, s.Selected("plugins"))
+	assert.False(t, s.Contains("envs/staging"))
+
+	_, e = Select(p, []string{"envs/nope", "envs/[", "envs/prod"})
+	assert.NotNil(t, e)
+
+	s, e = Select(p, []string{"envs/staging/comp1"})
+	assert.Nil(t, e)
+	buf := &bytes.Buffer{}
+	assert.Nil(t, Print(buf, p, s, FormatJSON))
+	var printed struct {
+		Accounts map[string]interface{} `json:"accounts"`
+		Envs     map[string]struct {
+			Components map[string]interface{} `json:"components"`
+		} `json:"envs"`
+		Global  interface{}            `json:"global"`
+		Modules map[string]interface{} `json:"modules"`
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &printed))
+	assert.Empty(t, printed.Accounts)
+	assert.Nil(t, printed.Global)
+	assert.Empty(t, printed.Modules)
+	assert.Len(t, printed.Envs, 1)
+	assert.Len(t, printed.Envs["staging"].Components, 1)
+	assert.Contains(t, printed.Envs["staging"].Components, "comp1")
 }
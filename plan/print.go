@@ -0,0 +1,151 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Formats fogg plan can print in.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Print writes the parts of the plan in s, or all of it when s is nil, in
+// the given format. Keys are sorted in every format so the same config always
+// prints the same plan.
+func Print(w io.Writer, p *Plan, s *Selection, format string) error {
+	b, e := json.Marshal(p)
+	if e != nil {
+		return errors.Wrap(e, "unable to serialize plan")
+	}
+	// numbers stay json.Numbers, so large integers in extra_vars print as
+	// they were written
+	var tree interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	e = d.Decode(&tree)
+	if e != nil {
+		return errors.Wrap(e, "unable to serialize plan")
+	}
+	s.filterTree(tree)
+
+	switch format {
+	case FormatJSON:
+		out, e := json.MarshalIndent(tree, "", "  ")
+		if e != nil {
+			return errors.Wrap(e, "unable to serialize plan")
+		}
+		_, e = fmt.Fprintf(w, "%s\n", out)
+		return e
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		e = enc.Encode(yamlNumbers(tree))
+		if e != nil {
+			return errors.Wrap(e, "unable to serialize plan")
+		}
+		return enc.Close()
+	case FormatText, "":
+		printText(w, tree, 0)
+		return nil
+	}
+	return errors.Errorf("unknown plan format %q, use %s, %s or %s", format, FormatText, FormatJSON, FormatYAML)
+}
+
+// yamlNumbers replaces the json.Numbers in a tree decoded from JSON with
+// YAML scalars, which yaml would otherwise quote as strings.
+func yamlNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = yamlNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = yamlNumbers(item)
+		}
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(t.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: t.String()}
+	}
+	return v
+}
+
+// printText writes a tree decoded from JSON as indented key: value lines.
+// Lists of plain values go on one line.
+func printText(w io.Writer, v interface{}, depth int) {
+	indent := strings.Repeat("\t", depth)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if isNested(t[k]) {
+				fmt.Fprintf(w, "%s%s:\n", indent, k)
+				printText(w, t[k], depth+1)
+			} else {
+				fmt.Fprintf(w, "%s%s: %s\n", indent, k, textValue(t[k]))
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			fmt.Fprintf(w, "%s-\n", indent)
+			printText(w, item, depth+1)
+		}
+	default:
+		fmt.Fprintf(w, "%s%s\n", indent, textValue(t))
+	}
+}
+
+// isNested is true for values that print on lines of their own.
+func isNested(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return len(t) > 0
+	case []interface{}:
+		for _, item := range t {
+			if isNested(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func textValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	case []interface{}:
+		items := make([]string, len(t))
+		for i, item := range t {
+			items[i] = textValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		return "{}"
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,137 @@
+package plan
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// Selection is the parts of a plan picked with --only. Parts are named by
+// address: global, plugins, accounts/<account>,
+// accounts/<account>/<component>, envs/<env>, envs/<env>/<component> and
+// modules/<module>.
+type Selection struct {
+	// addresses is nil when everything is selected
+	addresses map[string]bool
+}
+
+// Select picks the parts of p whose address matches one of patterns, which
+// can be globs like envs/*/db. Picking an account or env picks all of its
+// components. No patterns picks everything.
+func Select(p *Plan, patterns []string) (*Selection, error) {
+	s := &Selection{}
+	if len(patterns) == 0 {
+		return s, nil
+	}
+
+	all := Addresses(p)
+	s.addresses = map[string]bool{}
+	var errs *multierror.Error
+	for _, pattern := range patterns {
+		// so that paths like terraform/envs/staging/ work too
+		pattern = strings.TrimPrefix(strings.Trim(pattern, "/"), "terraform/")
+		matched := false
+		for _, address := range all {
+			ok, e := path.Match(pattern, address)
+			if e != nil {
+				return nil, errors.Wrapf(e, "bad pattern %s", pattern)
+			}
+			if ok {
+				s.addresses[address] = true
+				matched = true
+			}
+		}
+		if !matched {
+			errs = multierror.Append(errs, errors.Errorf("%s doesn't match global, plugins or any account, env, component or module", pattern))
+		}
+	}
+	return s, errs.ErrorOrNil()
+}
+
+// Addresses lists the address of everything in p, sorted.
+func Addresses(p *Plan) []string {
+	addresses := []string{"global", "plugins"}
+	for name, a := range p.Accounts {
+		addresses = append(addresses, "accounts/"+name)
+		for component := range a.Components {
+			addresses = append(addresses, "accounts/"+name+"/"+component)
+		}
+	}
+	for name, env := range p.Envs {
+		addresses = append(addresses, "envs/"+name)
+		for component := range env.Components {
+			addresses = append(addresses, "envs/"+name+"/"+component)
+		}
+	}
+	for name := range p.Modules {
+		addresses = append(addresses, "modules/"+name)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// Selected says if address, or an account or env it's part of, was picked.
+func (s *Selection) Selected(address string) bool {
+	if s == nil || s.addresses == nil {
+		return true
+	}
+	for a := address; a != "."; a = path.Dir(a) {
+		if s.addresses[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains says if address or anything in it was picked. The account or env
+// of a picked component is contained, but not selected.
+func (s *Selection) Contains(address string) bool {
+	if s.Selected(address) {
+		return true
+	}
+	for a := range s.addresses {
+		if strings.HasPrefix(a, address+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTree removes what isn't contained in s from a plan decoded from
+// JSON.
+func (s *Selection) filterTree(tree interface{}) {
+	p, ok := tree.(map[string]interface{})
+	if !ok || s == nil || s.addresses == nil {
+		return
+	}
+	for _, address := range []string{"global", "plugins"} {
+		if !s.Selected(address) {
+			delete(p, address)
+		}
+	}
+	for _, kind := range []string{"accounts", "envs"} {
+		scopes, _ := p[kind].(map[string]interface{})
+		for name, scope := range scopes {
+			if !s.Contains(kind + "/" + name) {
+				delete(scopes, name)
+				continue
+			}
+			scope, _ := scope.(map[string]interface{})
+			components, _ := scope["components"].(map[string]interface{})
+			for component := range components {
+				if !s.Selected(kind + "/" + name + "/" + component) {
+					delete(components, component)
+				}
+			}
+		}
+	}
+	modules, _ := p["modules"].(map[string]interface{})
+	for name := range modules {
+		if !s.Selected("modules/" + name) {
+			delete(modules, name)
+		}
+	}
+}
@@ -22,6 +22,9 @@ const (
 	TypePluginFormatTar TypePluginFormat = "tar"
 )
 
+// TypePluginFormats are all the plugin formats we know how to install
+var TypePluginFormats = []TypePluginFormat{TypePluginFormatTar}
+
 // CustomPlugin is a custom plugin
 type CustomPlugin struct {
 	URL       string           `json:"url" validate:"required"`
@@ -78,7 +78,7 @@ func generateTar(t *testing.T, files []string) string {
 		header.Typeflag = tar.TypeReg
 
 		a.Nil(tw.WriteHeader(header))
-		_, err = fmt.Fprintf(tw, file)
+		_, err = fmt.Fprint(tw, file)
 		a.Nil(err)
 	}
 
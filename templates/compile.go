@@ -0,0 +1,113 @@
+package templates
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	"github.com/chanzuckerberg/fogg/util"
+	"github.com/gobuffalo/packr"
+	"github.com/pkg/errors"
+)
+
+// Set is every box in T, read and with the templates parsed, so that they can
+// be rendered for every account, env and component without doing that again.
+type Set struct {
+	Account          *Tree
+	Component        *Tree
+	Env              *Tree
+	Global           *Tree
+	Module           *Tree
+	ModuleInvocation *Tree
+	Repo             *Tree
+}
+
+// Tree is the files in one box, in the order packr walks them.
+type Tree struct {
+	Files []*File
+}
+
+// File is a file in a box. Files ending in .tmpl are templates.
+type File struct {
+	// Path is where the file is in its box, like fogg.tf.tmpl
+	Path string
+	// Source is the box and the path, like component/fogg.tf.tmpl
+	Source   string
+	Contents []byte
+	template *template.Template
+}
+
+// Compile reads every file in t and parses the templates.
+func (t *T) Compile() (*Set, error) {
+	s := &Set{}
+	for _, b := range []struct {
+		tree **Tree
+		box  packr.Box
+	}{
+		{&s.Account, t.Account},
+		{&s.Component, t.Component},
+		{&s.Env, t.Env},
+		{&s.Global, t.Global},
+		{&s.Module, t.Module},
+		{&s.ModuleInvocation, t.ModuleInvocation},
+		{&s.Repo, t.Repo},
+	} {
+		tree, e := compileBox(b.box)
+		if e != nil {
+			return nil, e
+		}
+		*b.tree = tree
+	}
+	return s, nil
+}
+
+func compileBox(box packr.Box) (*Tree, error) {
+	tree := &Tree{}
+	e := box.Walk(func(path string, f packr.File) error {
+		source := filepath.Join(box.Path, path)
+		contents, e := ioutil.ReadAll(f)
+		if e != nil {
+			return errors.Wrapf(e, "unable to read template %s", source)
+		}
+		file := &File{Path: path, Source: source, Contents: contents}
+		if filepath.Ext(path) == ".tmpl" {
+			file, e = Parse(source, contents)
+			if e != nil {
+				return e
+			}
+			file.Path = path
+		}
+		tree.Files = append(tree.Files, file)
+		return nil
+	})
+	return tree, e
+}
+
+// Parse parses contents as a template. Errors, including ones from Execute,
+// name source.
+func Parse(source string, contents []byte) (*File, error) {
+	t, e := template.New(source).Funcs(util.TemplateFuncs()).Parse(string(contents))
+	if e != nil {
+		return nil, errors.Wrapf(e, "unable to parse template %s", source)
+	}
+	return &File{Path: source, Source: source, Contents: contents, template: t}, nil
+}
+
+// Execute renders the template with data.
+func (f *File) Execute(w io.Writer, data interface{}) error {
+	if f.template == nil {
+		return errors.Errorf("%s is not a template", f.Source)
+	}
+	return f.template.Execute(w, data)
+}
+
+// File finds the file at path in the tree.
+func (t *Tree) File(path string) (*File, error) {
+	for _, f := range t.Files {
+		if f.Path == path {
+			return f, nil
+		}
+	}
+	return nil, errors.Errorf("no template %s", path)
+}
@@ -0,0 +1,36 @@
+package templates
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	s, e := Templates.Compile()
+	assert.Nil(t, e)
+
+	f, e := s.Component.File("fogg.tf.tmpl")
+	assert.Nil(t, e)
+	assert.Equal(t, "component/fogg.tf.tmpl", f.Source)
+
+	_, e = s.Component.File("nope.tmpl")
+	assert.NotNil(t, e)
+}
+
+func TestParseError(t *testing.T) {
+	_, e := Parse("component/bad.tf.tmpl", []byte("{{ .Foo "))
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "component/bad.tf.tmpl")
+}
+
+func TestExecuteError(t *testing.T) {
+	f, e := Parse("component/bad.tf.tmpl", []byte("{{ .Foo.Bar }}"))
+	assert.Nil(t, e)
+
+	buf := &bytes.Buffer{}
+	e = f.Execute(buf, struct{ Foo string }{"foo"})
+	assert.NotNil(t, e)
+	assert.Contains(t, e.Error(), "component/bad.tf.tmpl")
+}
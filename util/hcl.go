@@ -0,0 +1,90 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hclType is the terraform variable type for a value decoded from JSON.
+// Terraform (0.11) has no number or bool variables, those are strings.
+func hclType(value interface{}) string {
+	switch value.(type) {
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return "string"
+}
+
+var hclIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+
+// hclValue writes a value decoded from JSON as an HCL literal. Maps are
+// written one key per line, which fmtHcl then aligns. Bools are quoted,
+// terraform would turn them into "1" and "0" otherwise.
+func hclValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `""`
+	case string:
+		return hclString(v)
+	case bool:
+		return hclString(fmt.Sprintf("%t", v))
+	case json.Number:
+		return v.String()
+	case float64:
+		return fmt.Sprintf("%v", v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = hclValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "{}"
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		lines := make([]string, len(keys))
+		for i, k := range keys {
+			key := k
+			if !hclIdentifier.MatchString(k) {
+				key = hclString(k)
+			}
+			lines[i] = fmt.Sprintf("%s = %s", key, hclValue(v[k]))
+		}
+		return "{\n" + strings.Join(lines, "\n") + "\n}"
+	}
+	return hclString(fmt.Sprintf("%v", value))
+}
+
+var hclStringEscapes = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// hclEscapeInterpolation keeps terraform from interpolating ${ in s.
+func hclEscapeInterpolation(s string) string {
+	return strings.Replace(s, "${", "$${", -1)
+}
+
+// hclEscape escapes s for use inside a quoted HCL string, so that config
+// values end up in terraform exactly as they were written.
+func hclEscape(s string) string {
+	return hclEscapeInterpolation(hclStringEscapes.Replace(s))
+}
+
+// hclString quotes and escapes s as an HCL string.
+func hclString(s string) string {
+	return `"` + hclEscape(s) + `"`
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHCLType(t *testing.T) {
+	assert.Equal(t, "string", hclType("foo"))
+	assert.Equal(t, "string", hclType(json.Number("3")))
+	assert.Equal(t, "string", hclType(true))
+	assert.Equal(t, "list", hclType([]interface{}{"a"}))
+	assert.Equal(t, "map", hclType(map[string]interface{}{}))
+}
+
+func TestHCLValue(t *testing.T) {
+	data := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"foo", `"foo"`},
+		{"say \"hi\"\n\\", `"say \"hi\"\n\\"`},
+		{"${var.foo}", `"$${var.foo}"`},
+		{json.Number("3.5"), `3.5`},
+		{false, `"false"`},
+		{[]interface{}{"a", json.Number("1")}, `["a", 1]`},
+		{map[string]interface{}{}, `{}`},
+		{map[string]interface{}{"b": "x", "a-1": []interface{}{}, "a b": true}, "{\n\"a b\" = \"true\"\na-1 = []\nb = \"x\"\n}"},
+	}
+	for _, test := range data {
+		t.Run("", func(t *testing.T) {
+			assert.Equal(t, test.expected, hclValue(test.value))
+		})
+	}
+}
+
+func TestHCLEscape(t *testing.T) {
+	assert.Equal(t, `plain`, hclEscape("plain"))
+	assert.Equal(t, `a \"b\" \\ \t$${c}`, hclEscape("a \"b\" \\ \t${c}"))
+	assert.Equal(t, `"$${c}"`, hclEscapeInterpolation(`"${c}"`))
+	assert.Equal(t, `"x"`, hclString("x"))
+}
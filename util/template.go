@@ -1,8 +1,6 @@
 package util
 
 import (
-	"io"
-	"io/ioutil"
 	"reflect"
 	"text/template"
 
@@ -24,12 +22,15 @@ func dict(in interface{}) map[string]interface{} {
 	return nil
 }
 
-func OpenTemplate(source io.Reader) *template.Template {
-	s, err := ioutil.ReadAll(source)
-	if err != nil {
-		log.Panic(err)
-	}
+// TemplateFuncs is the functions fogg's templates can use, sprig's along
+// with our own.
+func TemplateFuncs() template.FuncMap {
 	funcs := sprig.TxtFuncMap()
 	funcs["dict"] = dict
-	return template.Must(template.New("tmpl").Funcs(funcs).Parse(string(s)))
+	funcs["hclEscape"] = hclEscape
+	funcs["hclEscapeInterpolation"] = hclEscapeInterpolation
+	funcs["hclString"] = hclString
+	funcs["hclType"] = hclType
+	funcs["hclValue"] = hclValue
+	return funcs
 }